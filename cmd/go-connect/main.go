@@ -2,14 +2,15 @@
 package main
 
 import (
+	"crypto/tls"
+	"errors"
 	"fmt"
-	"io"
 	"net"
 	"os"
 	"os/signal"
+	"os/user"
 	"strconv"
 	"strings"
-	"sync"
 	"syscall"
 	"time"
 
@@ -19,6 +20,10 @@ import (
 	"github.com/crimson-and-clover/go-connect/pkg/transport"
 )
 
+// exitTimeout is the exit status when an idle, read or write timeout
+// closes the session, as with timeout(1).
+const exitTimeout = 124
+
 func main() {
 	opts, err := config.Parse()
 	if err != nil {
@@ -27,8 +32,35 @@ func main() {
 	}
 
 	if opts.ListenMode {
-		listener := netcat.NewListener(opts.ListenPort, opts.Verbose)
+		listener, err := newListener(opts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
 		if err := listener.Listen(); err != nil {
+			exitOnError(err)
+		}
+		return
+	}
+
+	if opts.TLSProbe {
+		if err := runTLSProbe(opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if opts.ShowCerts {
+		if err := runShowCerts(opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if opts.UDP {
+		if err := runUDPClient(opts); err != nil {
 			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 			os.Exit(1)
 		}
@@ -44,27 +76,42 @@ func main() {
 	}
 
 	if err := runClient(opts); err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		exitOnError(err)
 	}
 }
 
 func runClient(opts *config.Options) error {
 	var conn net.Conn
-	var err error
 
-	if opts.TLSEnable {
+	dial, err := dialOptions(opts)
+	if err != nil {
+		return err
+	}
+	limit, err := rateLimit(opts)
+	if err != nil {
+		return err
+	}
+	faults, err := faultOptions(opts)
+	if err != nil {
+		return err
+	}
+	if opts.Verbose {
+		if !dial.Bind.IsZero() {
+			fmt.Fprintf(os.Stderr, "Binding outgoing connections to %s\n", dial.Bind)
+		}
+		if dial.Resolver != nil {
+			fmt.Fprintf(os.Stderr, "Resolving names via %s\n", dial.Resolver)
+		}
+	}
+
+	if opts.QUIC {
+		conn, err = dialQUIC(opts)
+	} else if opts.TLSEnable {
 		// TLS direct connection or via proxy
 		conn, err = dialWithTLS(opts)
 	} else {
 		// Create dialer based on proxy configuration
-		dialerConfig := proxy.Config{
-			Timeout:   opts.Timeout,
-			TLSVerify: !opts.TLSVerify, // -k means skip verification
-			Verbose:   opts.Verbose,
-		}
-
-		dialer, err2 := proxy.NewDialer(opts.ProxyURL, dialerConfig)
+		dialer, err2 := proxy.NewDialer(opts.ProxyURL, proxyConfig(opts, dial))
 		if err2 != nil {
 			return err2
 		}
@@ -78,7 +125,7 @@ func runClient(opts *config.Options) error {
 		}
 
 		// Connect to target
-		conn, err = dialer.Dial("tcp", opts.TargetAddress())
+		conn, err = dialer.Dial(opts.Network(), opts.TargetAddress())
 	}
 
 	if err != nil {
@@ -94,29 +141,21 @@ func runClient(opts *config.Options) error {
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 
-	// Bidirectional copy
-	var wg sync.WaitGroup
-	wg.Add(2)
-
-	go func() {
-		defer wg.Done()
-		_, _ = io.Copy(conn, os.Stdin)
-	}()
-
-	go func() {
-		defer wg.Done()
-		_, _ = io.Copy(os.Stdout, conn)
-	}()
-
-	// Wait for either direction to finish or signal
-	done := make(chan struct{})
+	// The limiter is outermost so that each throttled chunk, rather than
+	// a whole Write, gets its own write deadline.
+	conn = limit.Wrap(sessionTimeouts(opts).Wrap(faults.Wrap(conn, opts.Verbose)), opts.Verbose)
+	relayCh := make(chan error, 1)
 	go func() {
-		wg.Wait()
-		close(done)
+		relayCh <- netcat.Relay(conn, os.Stdin, os.Stdout)
 	}()
 
+	// Wait for both directions to finish, a timeout or a signal
 	select {
-	case <-done:
+	case err := <-relayCh:
+		if err != nil && opts.Verbose {
+			fmt.Fprintf(os.Stderr, "Closing connection: %v\n", err)
+		}
+		return err
 	case <-sigCh:
 		if opts.Verbose {
 			fmt.Fprintln(os.Stderr, "\nInterrupted")
@@ -126,6 +165,225 @@ func runClient(opts *config.Options) error {
 	return nil
 }
 
+// sessionTimeouts returns the -i, --read-timeout and --write-timeout
+// settings.
+func sessionTimeouts(opts *config.Options) netcat.Timeouts {
+	return netcat.Timeouts{
+		Idle:  opts.IdleTimeout,
+		Read:  opts.ReadTimeout,
+		Write: opts.WriteTimeout,
+	}
+}
+
+// rateLimit parses the --limit-rate settings.
+func rateLimit(opts *config.Options) (netcat.RateLimit, error) {
+	var limit netcat.RateLimit
+	if opts.LimitRate == "" {
+		return limit, nil
+	}
+
+	send, receive, split := strings.Cut(opts.LimitRate, "/")
+	if !split {
+		receive = send
+	}
+	var err error
+	if limit.Send, err = transport.ParseSize(send); err != nil {
+		return limit, fmt.Errorf("invalid --limit-rate: %w", err)
+	}
+	if limit.Receive, err = transport.ParseSize(receive); err != nil {
+		return limit, fmt.Errorf("invalid --limit-rate: %w", err)
+	}
+	if opts.LimitCombined {
+		limit.Combined, limit.Send, limit.Receive = limit.Send, 0, 0
+	}
+
+	if opts.LimitBurst != "" {
+		if limit.Burst, err = transport.ParseSize(opts.LimitBurst); err != nil {
+			return limit, fmt.Errorf("invalid --limit-burst: %w", err)
+		}
+	}
+	return limit, nil
+}
+
+// faultOptions reads the --fault-file settings, then applies --fault.
+func faultOptions(opts *config.Options) (transport.FaultOptions, error) {
+	var faults transport.FaultOptions
+	if opts.FaultFile != "" {
+		spec, err := os.ReadFile(opts.FaultFile)
+		if err != nil {
+			return faults, fmt.Errorf("failed to read fault file: %w", err)
+		}
+		if err := transport.ParseFaultOptions(string(spec), &faults); err != nil {
+			return faults, fmt.Errorf("%s: %w", opts.FaultFile, err)
+		}
+	}
+	if err := transport.ParseFaultOptions(opts.Fault, &faults); err != nil {
+		return faults, err
+	}
+	return faults, nil
+}
+
+// exitOnError ends the process after a failed session. A session closed
+// by a timeout was already reported in verbose mode and exits with
+// exitTimeout; any other error is reported and exits with status 1.
+func exitOnError(err error) {
+	var timeoutErr *transport.TimeoutError
+	if errors.As(err, &timeoutErr) {
+		os.Exit(exitTimeout)
+	}
+	fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	os.Exit(1)
+}
+
+// newListener creates the listener for -l, on a TCP port, with -U on a
+// Unix socket or with --quic on a UDP port. With -T, or always with
+// --quic, it accepts TLS.
+func newListener(opts *config.Options) (*netcat.Listener, error) {
+	limit, err := rateLimit(opts)
+	if err != nil {
+		return nil, err
+	}
+	faults, err := faultOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var tlsConfig *tls.Config
+	if opts.TLSEnable || opts.QUIC {
+		serverOpts, err := serverTLSOptions(opts)
+		if err != nil {
+			return nil, err
+		}
+		if tlsConfig, err = transport.NewServerTLSConfig(serverOpts); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.QUIC {
+		listener := netcat.NewQUICListener(opts.ListenPort, tlsConfig, opts.Verbose)
+		listener.SetTimeouts(sessionTimeouts(opts))
+		listener.SetRateLimit(limit)
+		listener.SetFaults(faults)
+		return listener, nil
+	}
+
+	if !opts.Unix {
+		listener := netcat.NewListener(opts.ListenPort, opts.Verbose)
+		listener.SetMultipathTCP(opts.MultipathTCP)
+		listener.SetFastOpen(opts.FastOpen)
+		listener.SetTLS(tlsConfig)
+		listener.SetTimeouts(sessionTimeouts(opts))
+		listener.SetRateLimit(limit)
+		listener.SetFaults(faults)
+		return listener, nil
+	}
+
+	listener := netcat.NewUnixListener(opts.ListenPath, opts.SeqPacket, opts.Verbose)
+	uid, gid := -1, -1
+	if opts.SocketOwner != "" {
+		if uid, gid, err = lookupOwner(opts.SocketOwner); err != nil {
+			return nil, err
+		}
+	}
+	listener.SetSocketPermissions(opts.SocketMode, uid, gid)
+	listener.SetTLS(tlsConfig)
+	listener.SetTimeouts(sessionTimeouts(opts))
+	listener.SetRateLimit(limit)
+	listener.SetFaults(faults)
+	return listener, nil
+}
+
+// lookupOwner resolves a user[:group] owner, by name or numeric ID. An
+// omitted part is returned as -1.
+func lookupOwner(owner string) (uid, gid int, err error) {
+	userName, groupName, _ := strings.Cut(owner, ":")
+
+	uid, err = lookupID(userName, func(name string) (string, error) {
+		u, err := user.Lookup(name)
+		if err != nil {
+			return "", err
+		}
+		return u.Uid, nil
+	})
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid socket owner: %w", err)
+	}
+
+	gid, err = lookupID(groupName, func(name string) (string, error) {
+		g, err := user.LookupGroup(name)
+		if err != nil {
+			return "", err
+		}
+		return g.Gid, nil
+	})
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid socket group: %w", err)
+	}
+
+	return uid, gid, nil
+}
+
+// lookupID returns the numeric ID for name, which may be numeric itself,
+// or -1 if name is empty.
+func lookupID(name string, lookup func(string) (string, error)) (int, error) {
+	if name == "" {
+		return -1, nil
+	}
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+	id, err := lookup(name)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(id)
+}
+
+// runUDPClient exchanges datagrams with the target until it stays idle
+// for -i, or else the connection timeout.
+func runUDPClient(opts *config.Options) error {
+	dial, err := dialOptions(opts)
+	if err != nil {
+		return err
+	}
+	if opts.Verbose {
+		if !dial.Bind.IsZero() {
+			fmt.Fprintf(os.Stderr, "Binding outgoing datagrams to %s\n", dial.Bind)
+		}
+		if dial.Resolver != nil {
+			fmt.Fprintf(os.Stderr, "Resolving names via %s\n", dial.Resolver)
+		}
+	}
+
+	dialer, err := proxy.NewDialer(opts.ProxyURL, proxyConfig(opts, dial))
+	if err != nil {
+		return err
+	}
+
+	if opts.Verbose {
+		if opts.ProxyURL != "" {
+			fmt.Fprintf(os.Stderr, "Sending datagrams to %s via %s\n", opts.TargetAddress(), opts.ProxyURL)
+		} else {
+			fmt.Fprintf(os.Stderr, "Sending datagrams to %s (direct)\n", opts.TargetAddress())
+		}
+	}
+
+	conn, err := dialer.Dial("udp", opts.TargetAddress())
+	if err != nil {
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	idle := opts.IdleTimeout
+	if idle == 0 {
+		idle = opts.Timeout
+	}
+	client := netcat.NewUDPClient(conn, idle, opts.Verbose)
+	client.SetLineMode(opts.UDPLines)
+	client.SetHexDump(opts.HexDump)
+	return client.Run(os.Stdin, os.Stdout)
+}
+
 // runScanMode runs port scanning mode.
 func runScanMode(opts *config.Options) error {
 	// Parse port range
@@ -157,50 +415,424 @@ func runScanMode(opts *config.Options) error {
 			opts.TargetHost, startPort, endPort, timeout)
 	}
 
+	dial, err := dialOptions(opts)
+	if err != nil {
+		return err
+	}
+	dial.Verbose = false // the scanner reports addresses itself
+
 	scanner := netcat.NewScanner(opts.TargetHost, startPort, endPort, timeout, opts.Verbose)
+	scanner.SetDialOptions(dial)
 	results := scanner.Scan()
 	scanner.PrintResults(results)
 
 	return nil
 }
 
-// dialWithTLS handles TLS connections, optionally through a proxy.
+// runTLSProbe reports which TLS versions and cipher suites the target accepts.
+func runTLSProbe(opts *config.Options) error {
+	tlsOpts, err := tlsOptions(opts)
+	if err != nil {
+		return err
+	}
+
+	dialer, err := newDialer(opts)
+	if err != nil {
+		return err
+	}
+
+	dial := func() (net.Conn, error) {
+		return dialPlain(dialer, opts)
+	}
+
+	report := transport.ProbeTLS(opts.TargetAddress(), dial, tlsOpts, opts.Timeout)
+	if opts.JSON {
+		return report.WriteJSON(os.Stdout)
+	}
+	report.WriteText(os.Stdout)
+	return nil
+}
+
+// runShowCerts prints the TLS connection details and peer certificate
+// chain. A second connection checks whether the session can be resumed.
+func runShowCerts(opts *config.Options) error {
+	tlsOpts, err := tlsOptions(opts)
+	if err != nil {
+		return err
+	}
+	tlsOpts.SessionCache = tls.NewLRUClientSessionCache(1)
+
+	// The handshake does not verify the peer, so that an expired or
+	// untrusted chain can still be shown; it is verified separately.
+	verifier, err := transport.NewTLSWrapper(tlsOpts)
+	if err != nil {
+		return err
+	}
+	skipped := tlsOpts.SkipVerify && len(tlsOpts.Pins) == 0 && tlsOpts.OCSPMode == ""
+	tlsOpts.SkipVerify = true
+	tlsOpts.Pins = nil
+	tlsOpts.OCSPMode = ""
+	tlsWrapper, err := transport.NewTLSWrapper(tlsOpts)
+	if err != nil {
+		return err
+	}
+
+	dialer, err := newDialer(opts)
+	if err != nil {
+		return err
+	}
+
+	handshake := func() (tls.ConnectionState, error) {
+		conn, err := dialPlain(dialer, opts)
+		if err != nil {
+			return tls.ConnectionState{}, err
+		}
+		tlsConn, err := tlsWrapper.Wrap(conn, opts.Timeout)
+		if err != nil {
+			return tls.ConnectionState{}, err
+		}
+		defer func() { _ = tlsConn.Close() }()
+
+		// TLS 1.3 session tickets arrive after the handshake and are
+		// only processed by a read.
+		_ = tlsConn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		_, _ = tlsConn.Read(make([]byte, 1))
+
+		return tlsConn.(*tls.Conn).ConnectionState(), nil
+	}
+
+	state, err := handshake()
+	if err != nil {
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+	report := transport.NewConnectionReport(opts.TargetAddress(), state)
+	report.SetVerification(verifier.Verify(state), skipped)
+	report.Revocation = verifier.OCSPResult()
+
+	if resumed, err := handshake(); err == nil {
+		report.ResumptionSupported = &resumed.DidResume
+	} else if opts.Verbose {
+		fmt.Fprintf(os.Stderr, "Reconnect for resumption check failed: %v\n", err)
+	}
+
+	if opts.JSON {
+		return report.WriteJSON(os.Stdout)
+	}
+	report.WriteText(os.Stdout)
+	return nil
+}
+
+// dialWithTLS handles TLS connections, optionally through a proxy and
+// after a STARTTLS preamble.
 func dialWithTLS(opts *config.Options) (net.Conn, error) {
-	if opts.ProxyURL == "" {
+	// Load the client certificate before connecting so that errors
+	// surface without a dangling proxy tunnel.
+	tlsOpts, err := tlsOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.ProxyURL == "" && opts.StartTLS == "" {
 		// Direct TLS connection
-		return transport.DialAndWrap(
-			opts.TargetAddress(),
-			opts.Timeout,
-			opts.TargetHost,
-			opts.TLSVerify,
-			opts.Verbose,
-		)
+		dial, err := dialOptions(opts)
+		if err != nil {
+			return nil, err
+		}
+		return transport.DialAndWrap(opts.Network(), opts.TargetAddress(), opts.Timeout, dial, tlsOpts)
 	}
 
-	// First connect through proxy, then wrap with TLS
-	dialerConfig := proxy.Config{
-		Timeout:   opts.Timeout,
-		TLSVerify: !opts.TLSVerify,
-		Verbose:   opts.Verbose,
+	tlsWrapper, err := transport.NewTLSWrapper(tlsOpts)
+	if err != nil {
+		return nil, err
 	}
 
-	dialer, err := proxy.NewDialer(opts.ProxyURL, dialerConfig)
+	// First connect (through the proxy, if any), then wrap with TLS
+	dialer, err := newDialer(opts)
 	if err != nil {
 		return nil, err
 	}
 
 	if opts.Verbose {
-		fmt.Fprintf(os.Stderr, "Connecting to %s via %s, then upgrading to TLS\n",
-			opts.TargetAddress(), opts.ProxyURL)
+		if opts.ProxyURL != "" {
+			fmt.Fprintf(os.Stderr, "Connecting to %s via %s, then upgrading to TLS\n",
+				opts.TargetAddress(), opts.ProxyURL)
+		} else {
+			fmt.Fprintf(os.Stderr, "Connecting to %s (direct), then upgrading to TLS\n", opts.TargetAddress())
+		}
 	}
 
-	conn, err := dialer.Dial("tcp", opts.TargetAddress())
+	// Wrap the connection with TLS
+	return tlsWrapper.DialTLS(func() (net.Conn, error) {
+		return dialPlain(dialer, opts)
+	}, opts.Timeout)
+}
+
+// dialQUIC connects to the target over QUIC and opens a stream.
+func dialQUIC(opts *config.Options) (net.Conn, error) {
+	tlsOpts, err := tlsOptions(opts)
 	if err != nil {
 		return nil, err
 	}
+	dial, err := dialOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	return transport.DialQUIC(opts.TargetAddress(), opts.Timeout, dial, tlsOpts)
+}
 
-	// Wrap the connection with TLS
-	tlsWrapper := transport.NewTLSWrapper(opts.TargetHost, opts.TLSVerify, opts.Verbose)
-	return tlsWrapper.Wrap(conn, opts.Timeout)
+// dialPlain connects to the target and runs the STARTTLS preamble, if
+// any, leaving the connection ready for the TLS handshake.
+func dialPlain(dialer proxy.Dialer, opts *config.Options) (net.Conn, error) {
+	conn, err := dialer.Dial(opts.Network(), opts.TargetAddress())
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.StartTLS != "" {
+		if err := transport.StartTLS(conn, opts.StartTLS, opts.TargetHost, opts.Timeout, opts.Verbose); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+
+	return conn, nil
 }
 
+// tlsOptions builds the TLS settings for the target from the command line.
+func tlsOptions(opts *config.Options) (transport.TLSOptions, error) {
+	serverName := opts.TargetHost
+	if opts.Unix {
+		// A socket path is no server name; --sni and --verify-name
+		// override this one.
+		serverName = "localhost"
+	}
+
+	tlsOpts := transport.TLSOptions{
+		ServerName:  serverName,
+		SkipVerify:  opts.TLSVerify,
+		Verbose:     opts.Verbose,
+		SNI:         opts.SNI,
+		NoSNI:       opts.NoSNI,
+		VerifyName:  opts.VerifyName,
+		CertFile:    opts.CertFile,
+		KeyFile:     opts.KeyFile,
+		KeyPassword: opts.KeyPassword,
+		CAFile:      opts.CAFile,
+		CAAppend:    opts.CAAppend,
+		ALPN:        opts.ALPN,
+		RequireALPN: opts.RequireALPN,
+		Pins:        opts.Pins,
+		KeyLogFile:  opts.KeyLogFile,
+	}
+
+	var err error
+	if opts.TLSMin != "" {
+		if tlsOpts.MinVersion, err = transport.ParseTLSVersion(opts.TLSMin); err != nil {
+			return tlsOpts, err
+		}
+	}
+	if opts.TLSMax != "" {
+		if tlsOpts.MaxVersion, err = transport.ParseTLSVersion(opts.TLSMax); err != nil {
+			return tlsOpts, err
+		}
+	}
+	if tlsOpts.CipherSuites, err = transport.ParseCipherSuites(opts.Ciphers); err != nil {
+		return tlsOpts, err
+	}
+	if tlsOpts.Curves, err = transport.ParseCurves(opts.Curves); err != nil {
+		return tlsOpts, err
+	}
+
+	if opts.ECH != "" {
+		if opts.ECH == "dns" {
+			name := opts.TargetHost
+			if opts.SNI != "" {
+				name = opts.SNI
+			}
+			var resolver *transport.Resolver
+			if resolver, err = echResolver(opts); err != nil {
+				return tlsOpts, err
+			}
+			tlsOpts.ECHConfigList, err = transport.LookupECHConfigList(name, opts.TargetPort, resolver, opts.Timeout)
+		} else {
+			tlsOpts.ECHConfigList, err = transport.LoadECHConfigList(opts.ECH)
+		}
+		if err != nil {
+			return tlsOpts, err
+		}
+		tlsOpts.ECHRetry = opts.ECHRetry
+	}
+
+	if opts.SessionFile != "" {
+		cache, err := transport.NewFileSessionCache(opts.SessionFile, opts.Verbose)
+		if err != nil {
+			return tlsOpts, err
+		}
+		tlsOpts.SessionCache = cache
+	}
+
+	if tlsOpts.OCSPMode, err = transport.ParseOCSPMode(opts.OCSPMode); err != nil {
+		return tlsOpts, err
+	}
+	if tlsOpts.OCSPMode == transport.OCSPFetch {
+		// Reach the OCSP responder through the same proxy as the target.
+		dialer, err := newDialer(opts)
+		if err != nil {
+			return tlsOpts, err
+		}
+		tlsOpts.OCSPDial = dialer.Dial
+		tlsOpts.OCSPTimeout = opts.Timeout
+	}
+
+	return tlsOpts, nil
+}
+
+// serverTLSOptions builds the TLS settings for listen mode from the
+// command line.
+func serverTLSOptions(opts *config.Options) (transport.ServerTLSOptions, error) {
+	serverOpts := transport.ServerTLSOptions{
+		CertFile:    opts.CertFile,
+		KeyFile:     opts.KeyFile,
+		KeyPassword: opts.KeyPassword,
+		// In listen mode, --cacert verifies client certificates.
+		ClientCAFile:   opts.CAFile,
+		ClientCAAppend: opts.CAAppend,
+		ALPN:           opts.ALPN,
+		KeyLogFile:     opts.KeyLogFile,
+		Verbose:        opts.Verbose,
+	}
+
+	var err error
+	if opts.TLSMin != "" {
+		if serverOpts.MinVersion, err = transport.ParseTLSVersion(opts.TLSMin); err != nil {
+			return serverOpts, err
+		}
+	}
+	if opts.TLSMax != "" {
+		if serverOpts.MaxVersion, err = transport.ParseTLSVersion(opts.TLSMax); err != nil {
+			return serverOpts, err
+		}
+	}
+	if serverOpts.CipherSuites, err = transport.ParseCipherSuites(opts.Ciphers); err != nil {
+		return serverOpts, err
+	}
+	if serverOpts.Curves, err = transport.ParseCurves(opts.Curves); err != nil {
+		return serverOpts, err
+	}
+	return serverOpts, nil
+}
+
+// newDialer creates the dialer for the target, direct or through the proxy.
+func newDialer(opts *config.Options) (proxy.Dialer, error) {
+	dial, err := dialOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	return proxy.NewDialer(opts.ProxyURL, proxyConfig(opts, dial))
+}
+
+// proxyConfig builds the proxy dialer configuration from the command line.
+func proxyConfig(opts *config.Options, dial transport.DialOptions) proxy.Config {
+	return proxy.Config{
+		Timeout:    opts.Timeout,
+		TLSVerify:  !opts.TLSVerify, // -k means skip verification
+		Verbose:    opts.Verbose,
+		CAFile:     opts.CAFile,
+		CAAppend:   opts.CAAppend,
+		Pins:       opts.ProxyPins,
+		KeyLogFile: opts.KeyLogFile,
+		Dial:       dial,
+	}
+}
+
+// dialOptions builds the settings for outgoing TCP connections from the
+// command line.
+func dialOptions(opts *config.Options) (transport.DialOptions, error) {
+	family := transport.FamilyAny
+	if opts.IPv4 {
+		family = transport.FamilyIPv4
+	} else if opts.IPv6 {
+		family = transport.FamilyIPv6
+	}
+
+	dial := transport.DialOptions{
+		Bind: transport.BindOptions{
+			Addr:      opts.SourceAddr,
+			Port:      opts.SourcePort,
+			Interface: opts.Interface,
+		},
+		Family:        family,
+		FallbackDelay: opts.FallbackDelay,
+		AllAddresses:  opts.AllAddresses,
+		Socket: transport.SocketOptions{
+			KeepAliveIdle:     opts.KeepAliveIdle,
+			KeepAliveInterval: opts.KeepAliveInterval,
+			KeepAliveCount:    opts.KeepAliveCount,
+			Nagle:             !opts.NoDelay,
+			SendBuffer:        opts.SendBuffer,
+			ReceiveBuffer:     opts.ReceiveBuffer,
+			Mark:              opts.Mark,
+			UserTimeout:       opts.UserTimeout,
+			FastOpen:          opts.FastOpen,
+		},
+		MultipathTCP: opts.MultipathTCP,
+		Verbose:      opts.Verbose,
+	}
+
+	if opts.TOS != "" {
+		tos, err := transport.ParseTOS(opts.TOS)
+		if err != nil {
+			return dial, err
+		}
+		dial.Socket.TOS = tos
+	}
+
+	overrides, err := transport.NewAddressOverrides(opts.Resolve, opts.ConnectTo)
+	if err != nil {
+		return dial, err
+	}
+	dial.Overrides = overrides
+
+	if opts.DNS != "" || opts.DNSViaProxy {
+		resolver, err := newResolver(opts, dial)
+		if err != nil {
+			return dial, err
+		}
+		dial.Resolver = resolver
+	}
+	return dial, nil
+}
+
+// newResolver creates the resolver for --dns. dial must not use a custom
+// resolver itself: with --dns-via-proxy the proxy is located with the
+// system resolver.
+func newResolver(opts *config.Options, dial transport.DialOptions) (*transport.Resolver, error) {
+	ropts := transport.ResolverOptions{
+		Bind:    dial.Bind,
+		Timeout: opts.Timeout,
+		Verbose: opts.Verbose,
+	}
+
+	if opts.DNSViaProxy {
+		dialer, err := proxy.NewDialer(opts.ProxyURL, proxyConfig(opts, dial))
+		if err != nil {
+			return nil, err
+		}
+		ropts.Dial = dialer.Dial
+	}
+
+	return transport.NewResolver(opts.DNS, ropts)
+}
+
+// echResolver returns the resolver for the ECH HTTPS record lookup: the
+// --dns resolver, or else the system nameserver.
+func echResolver(opts *config.Options) (*transport.Resolver, error) {
+	dial, err := dialOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	if dial.Resolver != nil {
+		return dial.Resolver, nil
+	}
+	return newResolver(opts, dial)
+}
@@ -2,4 +2,11 @@ module github.com/crimson-and-clover/go-connect
 
 go 1.25.2
 
-require golang.org/x/net v0.50.0
+require (
+	github.com/quic-go/quic-go v0.61.0
+	golang.org/x/crypto v0.54.0
+	golang.org/x/net v0.56.0
+	software.sslmate.com/src/go-pkcs12 v0.7.3
+)
+
+require golang.org/x/sys v0.47.0 // indirect
@@ -4,7 +4,10 @@ package config
 import (
 	"flag"
 	"fmt"
+	"net"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -16,12 +19,113 @@ type Options struct {
 	Timeout    time.Duration
 	Verbose    bool
 	ZeroMode   bool // Port scanning mode
+	UDP        bool // Send datagrams instead of opening a stream
+	UDPLines   bool // With -u, one datagram per input line
+	HexDump    bool // With -u, show datagrams as hex dumps
 	ListenMode bool
 	ListenPort int
+	ListenPath string // Unix socket to listen on with -l -U
 	SourceAddr string
+	SourcePort int    // -p when connecting, as in nc
+	Interface  string // Bind outgoing connections to this interface
 	QuitDelay  time.Duration
 	TargetHost string
 	TargetPort string
+
+	// Session timeouts: no data either way, no data from the peer, and
+	// a stalled write to the peer
+	IdleTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+
+	// Throughput limits: RATE or SEND/RECEIVE in bytes per second, shared
+	// by both directions with LimitCombined
+	LimitRate     string
+	LimitCombined bool
+	LimitBurst    string
+
+	// Fault injection: key=value settings, and a file of them
+	Fault     string
+	FaultFile string
+
+	// Unix domain sockets: the target (or, with -l, ListenPath) is a
+	// socket path, or an abstract name starting with "@"
+	Unix        bool
+	SeqPacket   bool
+	SocketMode  os.FileMode // Listening socket file mode, 0 to keep the default
+	SocketOwner string      // Listening socket file owner: user[:group]
+
+	// QUIC: relay a bidirectional stream of a QUIC connection, with TLS
+	QUIC bool
+
+	// Address family selection and Happy Eyeballs
+	IPv4          bool
+	IPv6          bool
+	FallbackDelay time.Duration
+	AllAddresses  bool
+
+	// DNS server (host:port, tls://host, https://url) and whether to
+	// query it through the proxy
+	DNS         string
+	DNSViaProxy bool
+
+	// TCP socket tuning
+	KeepAliveIdle     time.Duration
+	KeepAliveInterval time.Duration
+	KeepAliveCount    int
+	NoDelay           bool
+	SendBuffer        int
+	ReceiveBuffer     int
+	TOS               string // TOS byte or DSCP class name
+	Mark              int
+	UserTimeout       time.Duration
+	FastOpen          bool
+	MultipathTCP      bool
+
+	// Address overrides: host:port:addr and host:port:newhost:newport
+	Resolve   []string
+	ConnectTo []string
+
+	// TLS client certificate
+	CertFile    string
+	KeyFile     string
+	KeyPassword string
+
+	// TLS trust anchors
+	CAFile   string
+	CAAppend bool
+
+	// TLS server name overrides
+	SNI        string
+	NoSNI      bool
+	VerifyName string
+
+	// TLS ALPN
+	ALPN        []string
+	RequireALPN bool
+
+	// TLS protocol constraints
+	TLSMin   string
+	TLSMax   string
+	Ciphers  []string
+	Curves   []string
+	TLSProbe bool
+
+	// SPKI pins for the target and the HTTPS proxy
+	Pins      []string
+	ProxyPins []string
+
+	ShowCerts  bool
+	StartTLS   string
+	KeyLogFile string
+	ECH        string // ECHConfigList source: "dns", a file or base64
+	ECHRetry   bool
+	OCSPMode   string
+
+	// TLS sessions kept across runs, for resumption and QUIC 0-RTT
+	SessionFile string
+
+	JSON bool // Machine-readable output for reports
 }
 
 // Parse parses command-line arguments and returns Options.
@@ -29,19 +133,82 @@ func Parse() (*Options, error) {
 	opts := &Options{}
 
 	flag.StringVar(&opts.ProxyURL, "x", "", "Proxy URL (http://host:port, socks5://host:port, etc.)")
-	flag.BoolVar(&opts.TLSEnable, "T", false, "Enable TLS")
+	flag.BoolVar(&opts.TLSEnable, "T", false, "Enable TLS (with -l, accept TLS connections)")
 	flag.BoolVar(&opts.TLSVerify, "k", false, "Skip TLS certificate verification")
 	flag.DurationVar(&opts.Timeout, "t", 30*time.Second, "Connection timeout")
 	flag.BoolVar(&opts.Verbose, "v", false, "Verbose output")
 	flag.BoolVar(&opts.ZeroMode, "z", false, "Zero I/O mode (port scanning)")
+	flag.BoolVar(&opts.UDP, "u", false, "UDP mode: send each stdin read as a datagram and print replies until idle for -i")
+	flag.BoolVar(&opts.UDPLines, "lines", false, "With -u, send each stdin line as its own datagram")
+	flag.BoolVar(&opts.HexDump, "hex", false, "With -u, show sent and received datagrams as hex dumps")
 	flag.BoolVar(&opts.ListenMode, "l", false, "Listen mode")
-	flag.IntVar(&opts.ListenPort, "p", 0, "Port to listen on")
-	flag.StringVar(&opts.SourceAddr, "s", "", "Source address")
+	flag.BoolVar(&opts.Unix, "U", false, "Unix domain socket: the target (or, with -l, the socket to create) is a path, or @name for the abstract namespace")
+	flag.BoolVar(&opts.SeqPacket, "seqpacket", false, "With -U, use SOCK_SEQPACKET instead of SOCK_STREAM")
+	socketMode := flag.String("socket-mode", "", "With -l -U, file mode of the socket (octal, e.g. 0660)")
+	flag.StringVar(&opts.SocketOwner, "socket-owner", "", "With -l -U, owner of the socket: user[:group]")
+	flag.BoolVar(&opts.QUIC, "quic", false, "QUIC mode: relay a bidirectional stream over QUIC with TLS (also quic://host:port; with -l, listen on UDP)")
+	flag.IntVar(&opts.ListenPort, "p", 0, "Port to listen on, or source port when connecting")
+	flag.StringVar(&opts.SourceAddr, "s", "", "Source address for outgoing connections")
+	flag.StringVar(&opts.Interface, "interface", "", "Bind outgoing connections to this network interface (Linux)")
+	flag.BoolVar(&opts.IPv4, "4", false, "Use IPv4 addresses only")
+	flag.BoolVar(&opts.IPv6, "6", false, "Use IPv6 addresses only")
+	flag.DurationVar(&opts.FallbackDelay, "happy-eyeballs-delay", 0, "Delay before racing the other address family (default 300ms, negative disables)")
+	flag.BoolVar(&opts.AllAddresses, "all-addresses", false, "Try every resolved address in turn, each with the full timeout")
+	flag.StringVar(&opts.DNS, "dns", "", "DNS server: host[:port], tls://host[:port] or https://host/path (default: system resolver)")
+	flag.BoolVar(&opts.DNSViaProxy, "dns-via-proxy", false, "Send DNS queries over TCP through the proxy")
+	flag.Var((*stringList)(&opts.Resolve), "resolve", "Connect to host:port at these addresses: host:port:addr[,addr...] (repeatable)")
+	flag.Var((*stringList)(&opts.ConnectTo), "connect-to", "Connect to host:port via newhost:newport instead (repeatable; empty fields match any)")
+	flag.DurationVar(&opts.KeepAliveIdle, "keepalive-idle", 0, "Idle time before TCP keepalive probes start (enables keepalive)")
+	flag.DurationVar(&opts.KeepAliveInterval, "keepalive-interval", 0, "Interval between TCP keepalive probes (enables keepalive)")
+	flag.IntVar(&opts.KeepAliveCount, "keepalive-count", 0, "Unanswered TCP keepalive probes before dropping the connection (enables keepalive)")
+	flag.BoolVar(&opts.NoDelay, "nodelay", true, "Set TCP_NODELAY; --nodelay=false enables Nagle's algorithm")
+	flag.IntVar(&opts.SendBuffer, "sndbuf", 0, "Socket send buffer size in bytes (SO_SNDBUF)")
+	flag.IntVar(&opts.ReceiveBuffer, "rcvbuf", 0, "Socket receive buffer size in bytes (SO_RCVBUF)")
+	flag.StringVar(&opts.TOS, "tos", "", "IP TOS byte (0-255) or DSCP class name (ef, af41, cs1, ...)")
+	flag.IntVar(&opts.Mark, "mark", 0, "Firewall mark for outgoing sockets (SO_MARK, Linux)")
+	flag.DurationVar(&opts.UserTimeout, "tcp-user-timeout", 0, "Drop the connection when sent data stays unacknowledged this long (TCP_USER_TIMEOUT)")
+	flag.BoolVar(&opts.FastOpen, "tfo", false, "Use TCP Fast Open: send the first data in the SYN (Linux)")
+	flag.BoolVar(&opts.MultipathTCP, "mptcp", false, "Use Multipath TCP, falling back to TCP")
 	flag.DurationVar(&opts.QuitDelay, "q", 0, "Quit after EOF on stdin (with delay)")
+	flag.DurationVar(&opts.IdleTimeout, "i", 0, "Close the session when no data flows in either direction for this long (with -u, default -w)")
+	flag.DurationVar(&opts.ReadTimeout, "read-timeout", 0, "Close the session when the peer sends nothing for this long")
+	flag.DurationVar(&opts.WriteTimeout, "write-timeout", 0, "Close the session when a write to the peer stalls for this long")
+	flag.StringVar(&opts.LimitRate, "limit-rate", "", "Limit each direction to this many bytes per second (K, M, G suffixes); SEND/RECEIVE sets them separately")
+	flag.BoolVar(&opts.LimitCombined, "limit-combined", false, "Apply --limit-rate to both directions together")
+	flag.StringVar(&opts.Fault, "fault", "", "Inject faults: latency=,jitter=,bandwidth=,stall=PROB:DUR,corrupt=PROB,fragment=N,reset-bytes=,reset-after=,seed=")
+	flag.StringVar(&opts.FaultFile, "fault-file", "", "Read --fault settings from this file, one or more per line (--fault overrides)")
+	flag.StringVar(&opts.LimitBurst, "limit-burst", "", "Token bucket size for --limit-rate in bytes (default: 0.1s worth, at least 4K)")
+	flag.StringVar(&opts.CertFile, "cert", "", "TLS client certificate file (PEM or PKCS#12), or the server certificate with -l")
+	flag.StringVar(&opts.KeyFile, "key", "", "TLS private key file for --cert (PEM)")
+	flag.StringVar(&opts.KeyPassword, "key-pass", "", "Password for an encrypted private key or PKCS#12 file")
+	flag.StringVar(&opts.CAFile, "cacert", "", "Trusted CA certificates (PEM file or directory), replacing system roots; with -l, required issuers of client certificates")
+	flag.BoolVar(&opts.CAAppend, "cacert-append", false, "Add --cacert certificates to the system roots instead of replacing them")
+	flag.StringVar(&opts.SNI, "sni", "", "TLS server name indication to send (default: target host)")
+	flag.BoolVar(&opts.NoSNI, "no-sni", false, "Do not send a TLS server name indication")
+	flag.StringVar(&opts.VerifyName, "verify-name", "", "Name to verify the server certificate against (default: --sni, else target host)")
+	alpn := flag.String("alpn", "", "Comma-separated ALPN protocols to offer (e.g. h2,http/1.1)")
+	flag.BoolVar(&opts.RequireALPN, "alpn-required", false, "Fail if the server accepts none of the --alpn protocols")
+	flag.StringVar(&opts.TLSMin, "tls-min", "", "Minimum TLS version (1.0, 1.1, 1.2, 1.3)")
+	flag.StringVar(&opts.TLSMax, "tls-max", "", "Maximum TLS version (1.0, 1.1, 1.2, 1.3)")
+	ciphers := flag.String("ciphers", "", "Comma-separated TLS 1.0-1.2 cipher suites to offer")
+	curves := flag.String("curves", "", "Comma-separated key exchange curves (X25519, P256, P384, P521, X25519MLKEM768)")
+	flag.BoolVar(&opts.TLSProbe, "tls-probe", false, "Report which TLS versions and cipher suites the target accepts")
+	flag.StringVar(&opts.StartTLS, "starttls", "", "Upgrade to TLS in-protocol: smtp, imap, pop3, ftp, xmpp, ldap, postgres (implies -T)")
+	flag.StringVar(&opts.KeyLogFile, "keylog", os.Getenv("SSLKEYLOGFILE"), "Log TLS secrets to this file for Wireshark (default: $SSLKEYLOGFILE)")
+	flag.StringVar(&opts.SessionFile, "session-file", "", "Keep TLS sessions in this file to resume them in later runs (enables QUIC 0-RTT)")
+	flag.StringVar(&opts.ECH, "ech", "", "Encrypted Client Hello config: \"dns\" (HTTPS record), a file, or base64")
+	flag.BoolVar(&opts.ECHRetry, "ech-retry", false, "Reconnect with the server's retry configs if ECH is rejected")
+	flag.StringVar(&opts.OCSPMode, "ocsp", "", "Check revocation via OCSP: staple (check if stapled), require (require a staple), fetch (staple or query responder)")
+	flag.BoolVar(&opts.ShowCerts, "show-certs", false, "Show the TLS connection details and peer certificate chain, then exit")
+	flag.BoolVar(&opts.JSON, "json", false, "Write reports as JSON")
+	flag.Var((*stringList)(&opts.Pins), "pin", "Pin the target public key: sha256//BASE64 (repeatable)")
+	flag.Var((*stringList)(&opts.ProxyPins), "proxy-pin", "Pin the HTTPS proxy public key: sha256//BASE64 (repeatable)")
 
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage: %s [options] host port\n", os.Args[0])
-		fmt.Fprintf(os.Stderr, "       %s -l -p port\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [options] quic://host:port\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s -l -p port [--quic]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s [-l] -U [options] path\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "\nOptions:\n")
 		flag.PrintDefaults()
 	}
@@ -56,15 +223,155 @@ func Parse() (*Options, error) {
 		opts.Timeout = *wFlag
 	}
 
+	if strings.HasPrefix(flag.Arg(0), "quic://") {
+		opts.QUIC = true
+	}
+
+	opts.ALPN = splitList(*alpn)
+	opts.Ciphers = splitList(*ciphers)
+	opts.Curves = splitList(*curves)
+	if opts.RequireALPN && len(opts.ALPN) == 0 {
+		return nil, fmt.Errorf("--alpn-required needs --alpn")
+	}
+
+	if opts.StartTLS != "" {
+		opts.TLSEnable = true
+	}
+
+	if opts.IPv4 && opts.IPv6 {
+		return nil, fmt.Errorf("-4 and -6 are mutually exclusive")
+	}
+
+	if opts.DNSViaProxy && opts.ProxyURL == "" {
+		return nil, fmt.Errorf("--dns-via-proxy needs -x")
+	}
+	// The system nameserver is usually a local address the proxy cannot reach.
+	if opts.DNSViaProxy && opts.DNS == "" {
+		return nil, fmt.Errorf("--dns-via-proxy needs --dns")
+	}
+
+	if (opts.LimitCombined || opts.LimitBurst != "") && opts.LimitRate == "" {
+		return nil, fmt.Errorf("--limit-combined and --limit-burst need --limit-rate")
+	}
+	if opts.LimitCombined && strings.Contains(opts.LimitRate, "/") {
+		return nil, fmt.Errorf("--limit-combined needs a single --limit-rate")
+	}
+
+	if *socketMode != "" {
+		mode, err := strconv.ParseUint(*socketMode, 8, 32)
+		if err != nil || mode > 0o777 {
+			return nil, fmt.Errorf("invalid socket mode: %s", *socketMode)
+		}
+		opts.SocketMode = os.FileMode(mode)
+	}
+	if (opts.SocketMode != 0 || opts.SocketOwner != "") && !(opts.ListenMode && opts.Unix) {
+		return nil, fmt.Errorf("--socket-mode and --socket-owner need -l -U")
+	}
+	if opts.SeqPacket && !opts.Unix {
+		return nil, fmt.Errorf("--seqpacket needs -U")
+	}
+	if opts.Unix {
+		switch {
+		case opts.ProxyURL != "":
+			return nil, fmt.Errorf("-x is not supported with -U")
+		case opts.UDP:
+			return nil, fmt.Errorf("-u and -U are mutually exclusive")
+		case opts.ZeroMode:
+			return nil, fmt.Errorf("-z is not supported with -U")
+		case opts.SourceAddr != "" || opts.Interface != "":
+			return nil, fmt.Errorf("-s and --interface are not supported with -U")
+		}
+	}
+
+	if (opts.UDPLines || opts.HexDump) && !opts.UDP {
+		return nil, fmt.Errorf("--lines and --hex need -u")
+	}
+	if opts.UDP {
+		switch {
+		case opts.ListenMode:
+			return nil, fmt.Errorf("-u is not supported in listen mode")
+		case opts.ZeroMode:
+			return nil, fmt.Errorf("-u is not supported with -z")
+		case opts.TLSEnable || opts.TLSProbe || opts.ShowCerts:
+			return nil, fmt.Errorf("TLS is not supported with -u")
+		case opts.Fault != "" || opts.FaultFile != "":
+			return nil, fmt.Errorf("fault injection is not supported with -u")
+		}
+	}
+
+	if opts.QUIC {
+		switch {
+		case opts.ProxyURL != "":
+			return nil, fmt.Errorf("-x is not supported with QUIC")
+		case opts.UDP || opts.Unix:
+			return nil, fmt.Errorf("-u and -U are not supported with QUIC")
+		case opts.ZeroMode:
+			return nil, fmt.Errorf("-z is not supported with QUIC")
+		case opts.StartTLS != "" || opts.TLSProbe || opts.ShowCerts:
+			return nil, fmt.Errorf("--starttls, --tls-probe and --show-certs are not supported with QUIC")
+		case opts.MultipathTCP || opts.FastOpen:
+			return nil, fmt.Errorf("--mptcp and --tfo are not supported with QUIC")
+		}
+	}
+	if opts.SessionFile != "" && (opts.ListenMode || !(opts.TLSEnable || opts.QUIC)) {
+		return nil, fmt.Errorf("--session-file needs -T or QUIC when connecting")
+	}
+
+	if opts.ListenMode && opts.StartTLS != "" {
+		return nil, fmt.Errorf("--starttls is not supported in listen mode")
+	}
+
+	if opts.NoSNI && opts.SNI != "" {
+		return nil, fmt.Errorf("--sni and --no-sni are mutually exclusive")
+	}
+
 	if opts.ListenMode {
+		if opts.Unix {
+			if flag.NArg() != 1 {
+				return nil, fmt.Errorf("listen mode with -U requires a socket path")
+			}
+			opts.ListenPath = flag.Arg(0)
+			return opts, nil
+		}
 		if opts.ListenPort == 0 {
 			return nil, fmt.Errorf("listen mode requires -p port")
 		}
 		return opts, nil
 	}
 
-	// Validate target host and port
+	opts.SourcePort, opts.ListenPort = opts.ListenPort, 0
+	if opts.SourcePort < 0 || opts.SourcePort > 65535 {
+		return nil, fmt.Errorf("source port out of range: %d", opts.SourcePort)
+	}
+	if opts.SourceAddr != "" && net.ParseIP(opts.SourceAddr) == nil {
+		return nil, fmt.Errorf("invalid source address: %s", opts.SourceAddr)
+	}
+
 	args := flag.Args()
+	if opts.Unix {
+		if opts.SourcePort != 0 {
+			return nil, fmt.Errorf("-p is not supported with -U")
+		}
+		if len(args) != 1 {
+			return nil, fmt.Errorf("-U requires a socket path")
+		}
+		opts.TargetHost = args[0]
+		return opts, nil
+	}
+
+	if opts.QUIC && len(args) > 0 {
+		if rest, ok := strings.CutPrefix(args[0], "quic://"); ok && len(args) == 1 {
+			host, port, err := net.SplitHostPort(strings.TrimSuffix(rest, "/"))
+			if err != nil {
+				return nil, fmt.Errorf("invalid QUIC target %s: expected quic://host:port", args[0])
+			}
+			args = []string{host, port}
+		} else if ok {
+			args = append([]string{rest}, args[1:]...)
+		}
+	}
+
+	// Validate target host and port
 	if opts.ZeroMode && len(args) >= 2 {
 		// Port scanning mode can have host and port range
 		opts.TargetHost = args[0]
@@ -90,10 +397,47 @@ func Parse() (*Options, error) {
 	return opts, nil
 }
 
+// stringList is a flag.Value collecting repeated string flags.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// splitList splits a comma-separated flag value, dropping empty items.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Network returns the network to dial the target on.
+func (o *Options) Network() string {
+	switch {
+	case o.Unix && o.SeqPacket:
+		return "unixpacket"
+	case o.Unix:
+		return "unix"
+	case o.UDP:
+		return "udp"
+	default:
+		return "tcp"
+	}
+}
+
 // TargetAddress returns the full target address (host:port).
 func (o *Options) TargetAddress() string {
 	if o.TargetPort == "" {
 		return o.TargetHost
 	}
-	return o.TargetHost + ":" + o.TargetPort
+	return net.JoinHostPort(o.TargetHost, o.TargetPort)
 }
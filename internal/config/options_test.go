@@ -0,0 +1,23 @@
+package config
+
+import "testing"
+
+func TestTargetAddress(t *testing.T) {
+	tests := []struct {
+		host, port string
+		want       string
+	}{
+		{"example.com", "443", "example.com:443"},
+		{"192.0.2.1", "80", "192.0.2.1:80"},
+		{"::1", "443", "[::1]:443"},
+		{"2001:db8::1", "8080", "[2001:db8::1]:8080"},
+		{"/tmp/socket", "", "/tmp/socket"},
+	}
+
+	for _, tt := range tests {
+		o := &Options{TargetHost: tt.host, TargetPort: tt.port}
+		if got := o.TargetAddress(); got != tt.want {
+			t.Errorf("TargetAddress(%q, %q) = %q, want %q", tt.host, tt.port, got, tt.want)
+		}
+	}
+}
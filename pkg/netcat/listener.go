@@ -1,56 +1,216 @@
 package netcat
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
-	"io"
 	"net"
 	"os"
 	"os/signal"
-	"sync"
+	"strings"
 	"syscall"
+
+	"github.com/crimson-and-clover/go-connect/pkg/transport"
 )
 
 // Listener provides listen mode functionality.
 type Listener struct {
-	port    int
-	verbose bool
+	network   string
+	address   string
+	port      int
+	verbose   bool
+	multipath bool
+	fastOpen  bool
+	timeouts  Timeouts
+	rateLimit RateLimit
+	faults    transport.FaultOptions
+	tlsConfig *tls.Config
+
+	// Unix socket file permissions; zero mode and -1 IDs keep the
+	// defaults.
+	mode     os.FileMode
+	uid, gid int
 }
 
 // NewListener creates a new listener.
 func NewListener(port int, verbose bool) *Listener {
 	return &Listener{
+		network: "tcp",
+		address: fmt.Sprintf(":%d", port),
 		port:    port,
 		verbose: verbose,
+		uid:     -1,
+		gid:     -1,
+	}
+}
+
+// NewUnixListener creates a listener on a Unix domain socket: a file
+// system path, or an abstract name starting with "@" (Linux). With
+// seqPacket it uses SOCK_SEQPACKET instead of SOCK_STREAM.
+func NewUnixListener(path string, seqPacket bool, verbose bool) *Listener {
+	network := "unix"
+	if seqPacket {
+		network = "unixpacket"
+	}
+	return &Listener{
+		network: network,
+		address: path,
+		verbose: verbose,
+		uid:     -1,
+		gid:     -1,
+	}
+}
+
+// NewQUICListener creates a listener that accepts QUIC connections on a
+// UDP port, with TLS config, and relays the first stream of each.
+func NewQUICListener(port int, config *tls.Config, verbose bool) *Listener {
+	return &Listener{
+		network:   "quic",
+		address:   fmt.Sprintf(":%d", port),
+		port:      port,
+		verbose:   verbose,
+		tlsConfig: config,
+		uid:       -1,
+		gid:       -1,
+	}
+}
+
+// SetMultipathTCP accepts Multipath TCP connections as well as plain TCP.
+func (l *Listener) SetMultipathTCP(enable bool) {
+	l.multipath = enable
+}
+
+// SetFastOpen accepts data in the SYN of incoming connections (TCP Fast
+// Open).
+func (l *Listener) SetFastOpen(enable bool) {
+	l.fastOpen = enable
+}
+
+// SetTimeouts closes connections that stay idle, or whose reads or
+// writes stall, for longer than the given timeouts.
+func (l *Listener) SetTimeouts(t Timeouts) {
+	l.timeouts = t
+}
+
+// SetRateLimit caps the throughput of each connection.
+func (l *Listener) SetRateLimit(r RateLimit) {
+	l.rateLimit = r
+}
+
+// SetFaults injects network faults into each connection.
+func (l *Listener) SetFaults(f transport.FaultOptions) {
+	l.faults = f
+}
+
+// SetTLS accepts TLS connections with config instead of plaintext ones.
+func (l *Listener) SetTLS(config *tls.Config) {
+	l.tlsConfig = config
+}
+
+// SetSocketPermissions sets the mode and owner of a Unix socket file once
+// it is created. A zero mode or -1 uid or gid leaves that part unchanged.
+func (l *Listener) SetSocketPermissions(mode os.FileMode, uid, gid int) {
+	l.mode = mode
+	l.uid = uid
+	l.gid = gid
+}
+
+// String describes where the listener listens.
+func (l *Listener) String() string {
+	switch {
+	case l.network == "quic":
+		return fmt.Sprintf("UDP port %d (QUIC)", l.port)
+	case l.network == "tcp" && l.tlsConfig != nil:
+		return fmt.Sprintf("port %d (TLS)", l.port)
+	case l.network == "tcp":
+		return fmt.Sprintf("port %d", l.port)
+	case l.tlsConfig != nil:
+		return l.address + " (TLS)"
+	default:
+		return l.address
+	}
+}
+
+// listen opens the listening socket.
+func (l *Listener) listen() (net.Listener, error) {
+	if l.network == "quic" {
+		ln, err := transport.ListenQUIC(l.address, l.tlsConfig, l.verbose)
+		if err != nil {
+			return nil, fmt.Errorf("failed to listen on %s: %w", l.address, err)
+		}
+		return ln, nil
+	}
+
+	var lc net.ListenConfig
+	if l.multipath {
+		lc.SetMultipathTCP(true)
+	}
+	if l.fastOpen {
+		lc.Control = transport.FastOpenListenControl
+	}
+	ln, err := lc.Listen(context.Background(), l.network, l.address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", l.address, err)
+	}
+
+	if err := l.setPermissions(); err != nil {
+		_ = ln.Close()
+		return nil, err
+	}
+
+	if l.tlsConfig != nil {
+		// acceptLoop completes the handshakes.
+		return tls.NewListener(ln, l.tlsConfig), nil
+	}
+	return ln, nil
+}
+
+// setPermissions applies the Unix socket file mode and owner.
+func (l *Listener) setPermissions() error {
+	if l.mode == 0 && l.uid == -1 && l.gid == -1 {
+		return nil
+	}
+	if strings.HasPrefix(l.address, "@") {
+		return fmt.Errorf("abstract socket %s has no file permissions", l.address)
+	}
+	if l.mode != 0 {
+		if err := os.Chmod(l.address, l.mode); err != nil {
+			return fmt.Errorf("failed to set socket mode: %w", err)
+		}
+	}
+	if l.uid != -1 || l.gid != -1 {
+		if err := os.Chown(l.address, l.uid, l.gid); err != nil {
+			return fmt.Errorf("failed to set socket owner: %w", err)
+		}
+	}
+	if l.verbose {
+		if info, err := os.Stat(l.address); err == nil {
+			fmt.Fprintf(os.Stderr, "Socket %s has mode %v\n", l.address, info.Mode().Perm())
+		}
 	}
+	return nil
 }
 
-// Listen starts listening on the specified port.
+// Listen starts listening on the specified port or socket.
 func (l *Listener) Listen() error {
-	address := fmt.Sprintf(":%d", l.port)
-	ln, err := net.Listen("tcp", address)
+	ln, err := l.listen()
 	if err != nil {
-		return fmt.Errorf("failed to listen on %s: %w", address, err)
+		return err
 	}
 	defer func() { _ = ln.Close() }()
 
-	fmt.Fprintf(os.Stderr, "Listening on port %d...\n", l.port)
+	fmt.Fprintf(os.Stderr, "Listening on %s...\n", l)
 
 	// Handle shutdown signal
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 
 	// Accept connections in a goroutine
-	connCh := make(chan net.Conn, 1)
-	errCh := make(chan error, 1)
-
-	go func() {
-		conn, err := ln.Accept()
-		if err != nil {
-			errCh <- err
-			return
-		}
-		connCh <- conn
-	}()
+	connCh := make(chan net.Conn)
+	errCh := make(chan error)
+	done := make(chan struct{})
+	defer close(done)
+	go l.acceptLoop(ln, connCh, errCh, done)
 
 	select {
 	case <-sigCh:
@@ -63,12 +223,60 @@ func (l *Listener) Listen() error {
 	}
 }
 
+// acceptLoop accepts connections on ln and passes each to conns once its
+// TLS handshake, if any, has completed. The handshakes run in a goroutine
+// per connection, so that a stalled client holds up no other; failures
+// are reported in verbose mode and skipped. Once done is closed, further
+// connections are dropped.
+func (l *Listener) acceptLoop(ln net.Listener, conns chan<- net.Conn, errCh chan<- error, done <-chan struct{}) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			select {
+			case errCh <- err:
+			case <-done:
+			}
+			return
+		}
+
+		go func() {
+			if err := transport.ServerHandshake(conn); err != nil {
+				if l.verbose {
+					fmt.Fprintln(os.Stderr, err)
+				}
+				_ = conn.Close()
+				return
+			}
+			select {
+			case conns <- conn:
+			case <-done:
+				_ = conn.Close()
+			}
+		}()
+	}
+}
+
 // handleConnection handles a single client connection.
 func (l *Listener) handleConnection(conn net.Conn) error {
 	defer func() { _ = conn.Close() }()
 
 	if l.verbose {
-		fmt.Fprintf(os.Stderr, "Connection from %s\n", conn.RemoteAddr())
+		if strings.HasPrefix(l.network, "unix") {
+			peer := transport.PeerCredentials(conn)
+			if peer == "" {
+				peer = "unknown peer"
+			}
+			fmt.Fprintf(os.Stderr, "Connection on %s from %s\n", l.address, peer)
+		} else {
+			fmt.Fprintf(os.Stderr, "Connection from %s\n", conn.RemoteAddr())
+		}
+		if l.multipath || l.fastOpen {
+			fmt.Fprintf(os.Stderr, "Connection from %s: %s\n", conn.RemoteAddr(),
+				transport.DescribeTCPFeatures(conn, l.multipath, l.fastOpen))
+		}
+	}
+	if tlsConn, ok := conn.(interface{ ConnectionState() tls.ConnectionState }); ok {
+		fmt.Fprintf(os.Stderr, "TLS: %s\n", transport.DescribeServerState(tlsConn.ConnectionState()))
 	}
 
 	fmt.Fprintln(os.Stderr, "Connection established. Press Ctrl+C to close.")
@@ -77,28 +285,22 @@ func (l *Listener) handleConnection(conn net.Conn) error {
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 
-	// Bidirectional copy
-	var wg sync.WaitGroup
-	wg.Add(2)
-
-	go func() {
-		defer wg.Done()
-		_, _ = io.Copy(conn, os.Stdin)
-	}()
-
+	// The limiter is outermost so that each throttled chunk gets its own
+	// write deadline.
+	conn = l.rateLimit.Wrap(l.timeouts.Wrap(l.faults.Wrap(conn, l.verbose)), l.verbose)
+	relayCh := make(chan error, 1)
 	go func() {
-		defer wg.Done()
-		_, _ = io.Copy(os.Stdout, conn)
-	}()
-
-	done := make(chan struct{})
-	go func() {
-		wg.Wait()
-		close(done)
+		relayCh <- Relay(conn, os.Stdin, os.Stdout)
 	}()
 
 	select {
-	case <-done:
+	case err := <-relayCh:
+		if err != nil {
+			if l.verbose {
+				fmt.Fprintf(os.Stderr, "Closing connection: %v\n", err)
+			}
+			return err
+		}
 		if l.verbose {
 			fmt.Fprintln(os.Stderr, "Connection closed")
 		}
@@ -111,17 +313,16 @@ func (l *Listener) handleConnection(conn net.Conn) error {
 	return nil
 }
 
-// ListenAndServe listens on the port and serves connections.
+// ListenAndServe listens on the port or socket and serves connections.
 // If single is true, accepts only one connection; otherwise accepts continuously.
 func (l *Listener) ListenAndServe(single bool) error {
-	address := fmt.Sprintf(":%d", l.port)
-	ln, err := net.Listen("tcp", address)
+	ln, err := l.listen()
 	if err != nil {
-		return fmt.Errorf("failed to listen on %s: %w", address, err)
+		return err
 	}
 	defer func() { _ = ln.Close() }()
 
-	fmt.Fprintf(os.Stderr, "Listening on port %d...\n", l.port)
+	fmt.Fprintf(os.Stderr, "Listening on %s...\n", l)
 
 	// Handle shutdown signal
 	sigCh := make(chan os.Signal, 1)
@@ -129,20 +330,9 @@ func (l *Listener) ListenAndServe(single bool) error {
 
 	acceptCh := make(chan net.Conn)
 	errCh := make(chan error)
-
-	go func() {
-		for {
-			conn, err := ln.Accept()
-			if err != nil {
-				errCh <- err
-				return
-			}
-			acceptCh <- conn
-			if single {
-				return
-			}
-		}
-	}()
+	done := make(chan struct{})
+	defer close(done)
+	go l.acceptLoop(ln, acceptCh, errCh, done)
 
 	for {
 		select {
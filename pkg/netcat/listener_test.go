@@ -0,0 +1,55 @@
+package netcat
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/crimson-and-clover/go-connect/pkg/transport"
+)
+
+func TestAcceptLoopSilentClientBlocksNoOther(t *testing.T) {
+	cert, err := transport.GenerateCertificate([]string{"localhost"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln := tls.NewListener(tcp, &tls.Config{Certificates: []tls.Certificate{*cert}})
+	defer func() { _ = ln.Close() }()
+
+	conns := make(chan net.Conn)
+	errCh := make(chan error)
+	done := make(chan struct{})
+	defer close(done)
+	go (&Listener{}).acceptLoop(ln, conns, errCh, done)
+
+	// This client never starts the handshake.
+	silent, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = silent.Close() }()
+
+	client, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{InsecureSkipVerify: true, ServerName: "client.test"})
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer func() { _ = client.Close() }()
+
+	select {
+	case conn := <-conns:
+		defer func() { _ = conn.Close() }()
+		state := conn.(*tls.Conn).ConnectionState()
+		if !state.HandshakeComplete || state.ServerName != "client.test" {
+			t.Errorf("accepted connection: handshake complete %v, SNI %q", state.HandshakeComplete, state.ServerName)
+		}
+	case err := <-errCh:
+		t.Fatalf("Accept: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("a silent client blocked the next connection")
+	}
+}
@@ -0,0 +1,100 @@
+package netcat
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/crimson-and-clover/go-connect/pkg/transport"
+)
+
+// RateLimit caps the throughput of a relayed connection in bytes per
+// second. Zero leaves a direction unlimited.
+type RateLimit struct {
+	Send     int64 // to the peer
+	Receive  int64 // from the peer
+	Combined int64 // both directions together
+	Burst    int64 // bucket size in bytes; zero picks one from the rate
+}
+
+// IsZero reports whether no limit is set.
+func (r RateLimit) IsZero() bool {
+	return r.Send == 0 && r.Receive == 0 && r.Combined == 0
+}
+
+// Wrap applies the limits to conn. It returns conn itself if none is
+// set. In verbose mode the current rates are reported every second the
+// connection is active, and the averages when it is closed.
+func (r RateLimit) Wrap(conn net.Conn, verbose bool) net.Conn {
+	if r.IsZero() {
+		return conn
+	}
+
+	var read, write *transport.TokenBucket
+	if r.Combined > 0 {
+		read = transport.NewTokenBucket(r.Combined, r.Burst)
+		write = read
+	}
+	if r.Receive > 0 {
+		read = transport.NewTokenBucket(r.Receive, r.Burst)
+	}
+	if r.Send > 0 {
+		write = transport.NewTokenBucket(r.Send, r.Burst)
+	}
+
+	limited := transport.NewRateLimitedConn(conn, read, write)
+	if !verbose {
+		return limited
+	}
+
+	c := &rateReportConn{
+		RateLimitedConn: limited,
+		start:           time.Now(),
+		stop:            make(chan struct{}),
+	}
+	go c.report()
+	return c
+}
+
+// rateReportConn reports the throughput of a rate limited connection.
+type rateReportConn struct {
+	*transport.RateLimitedConn
+	start time.Time
+	stop  chan struct{}
+	once  sync.Once
+}
+
+func (c *rateReportConn) report() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	var lastRead, lastWritten int64
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			read, written := c.Stats()
+			if read == lastRead && written == lastWritten {
+				continue
+			}
+			fmt.Fprintf(os.Stderr, "Rate: sending %s/s, receiving %s/s\n",
+				transport.FormatSize(float64(written-lastWritten)), transport.FormatSize(float64(read-lastRead)))
+			lastRead, lastWritten = read, written
+		}
+	}
+}
+
+func (c *rateReportConn) Close() error {
+	c.once.Do(func() {
+		close(c.stop)
+		read, written := c.Stats()
+		elapsed := time.Since(c.start).Seconds()
+		fmt.Fprintf(os.Stderr, "Sent %s (%s/s), received %s (%s/s)\n",
+			transport.FormatSize(float64(written)), transport.FormatSize(float64(written)/elapsed),
+			transport.FormatSize(float64(read)), transport.FormatSize(float64(read)/elapsed))
+	})
+	return c.RateLimitedConn.Close()
+}
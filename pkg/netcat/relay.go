@@ -0,0 +1,60 @@
+package netcat
+
+import (
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	"github.com/crimson-and-clover/go-connect/pkg/transport"
+)
+
+// Timeouts end a relayed session when the peer or the link stalls.
+type Timeouts struct {
+	Idle  time.Duration // no data in either direction
+	Read  time.Duration // no data from the peer
+	Write time.Duration // a write to the peer makes no progress
+}
+
+// IsZero reports whether no timeout is set.
+func (t Timeouts) IsZero() bool {
+	return t.Idle == 0 && t.Read == 0 && t.Write == 0
+}
+
+// Wrap applies the timeouts to conn. It returns conn itself if none is
+// set.
+func (t Timeouts) Wrap(conn net.Conn) net.Conn {
+	if t.IsZero() {
+		return conn
+	}
+	td := transport.NewTimeoutDialer(conn, t.Read, t.Write)
+	td.SetIdleTimeout(t.Idle)
+	return td
+}
+
+// Relay copies in to conn and conn to out until both directions finish.
+// If a timeout fires in either direction, it returns the
+// *transport.TimeoutError at once, since the other direction may be
+// blocked on input that never comes; the caller closes conn. Other errors
+// end their direction silently, as with nc.
+func Relay(conn net.Conn, in io.Reader, out io.Writer) error {
+	errCh := make(chan error, 2)
+
+	go func() {
+		_, err := io.Copy(conn, in)
+		errCh <- err
+	}()
+
+	go func() {
+		_, err := io.Copy(out, conn)
+		errCh <- err
+	}()
+
+	for i := 0; i < 2; i++ {
+		var timeoutErr *transport.TimeoutError
+		if err := <-errCh; errors.As(err, &timeoutErr) {
+			return timeoutErr
+		}
+	}
+	return nil
+}
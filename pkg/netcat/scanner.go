@@ -8,6 +8,8 @@ import (
 	"strconv"
 	"sync"
 	"time"
+
+	"github.com/crimson-and-clover/go-connect/pkg/transport"
 )
 
 // ScanResult represents the result of scanning a single port.
@@ -16,6 +18,7 @@ type ScanResult struct {
 	Open    bool
 	Error   error
 	Latency time.Duration
+	Address string // remote address that accepted the connection
 }
 
 // Scanner provides port scanning functionality.
@@ -25,6 +28,7 @@ type Scanner struct {
 	timeout time.Duration
 	verbose bool
 	workers int
+	dial    transport.DialOptions
 }
 
 // NewScanner creates a new port scanner.
@@ -54,6 +58,12 @@ func NewSinglePortScanner(host string, port int, timeout time.Duration, verbose
 	}
 }
 
+// SetDialOptions sets the local binding, address family and Happy
+// Eyeballs behaviour of the probes.
+func (s *Scanner) SetDialOptions(opts transport.DialOptions) {
+	s.dial = opts
+}
+
 // Scan performs the port scan and returns results.
 func (s *Scanner) Scan() []ScanResult {
 	results := make([]ScanResult, 0, len(s.ports))
@@ -92,7 +102,7 @@ func (s *Scanner) scanPort(port int) ScanResult {
 	address := net.JoinHostPort(s.host, strconv.Itoa(port))
 	start := time.Now()
 
-	conn, err := net.DialTimeout("tcp", address, s.timeout)
+	conn, err := transport.NewDirectDialer(s.timeout, s.dial).Dial("tcp", address)
 	latency := time.Since(start)
 
 	if err != nil {
@@ -109,6 +119,7 @@ func (s *Scanner) scanPort(port int) ScanResult {
 		Port:    port,
 		Open:    true,
 		Latency: latency,
+		Address: conn.RemoteAddr().String(),
 	}
 }
 
@@ -118,7 +129,11 @@ func (s *Scanner) PrintResults(results []ScanResult) {
 	for _, r := range results {
 		if r.Open {
 			openCount++
-			fmt.Fprintf(os.Stderr, "Port %d open (%.2f ms)\n", r.Port, float64(r.Latency.Microseconds())/1000.0)
+			if s.verbose {
+				fmt.Fprintf(os.Stderr, "Port %d open at %s (%.2f ms)\n", r.Port, r.Address, float64(r.Latency.Microseconds())/1000.0)
+			} else {
+				fmt.Fprintf(os.Stderr, "Port %d open (%.2f ms)\n", r.Port, float64(r.Latency.Microseconds())/1000.0)
+			}
 		} else if s.verbose {
 			fmt.Fprintf(os.Stderr, "Port %d closed/filtered\n", r.Port)
 		}
@@ -127,9 +142,9 @@ func (s *Scanner) PrintResults(results []ScanResult) {
 }
 
 // CheckSinglePort checks if a single port is open.
-func CheckSinglePort(host string, port int, timeout time.Duration) bool {
+func CheckSinglePort(host string, port int, timeout time.Duration, opts transport.DialOptions) bool {
 	address := net.JoinHostPort(host, strconv.Itoa(port))
-	conn, err := net.DialTimeout("tcp", address, timeout)
+	conn, err := transport.NewDirectDialer(timeout, opts).Dial("tcp", address)
 	if err != nil {
 		return false
 	}
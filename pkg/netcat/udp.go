@@ -0,0 +1,172 @@
+package netcat
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// errIdle is returned by the receive loop when the idle timeout expires.
+var errIdle = errors.New("idle timeout")
+
+// UDPClient exchanges datagrams with a connected UDP peer: each read from
+// the input, or each line in line mode, is sent as one datagram and every
+// datagram received is written to the output.
+type UDPClient struct {
+	conn    net.Conn
+	idle    time.Duration
+	verbose bool
+	lines   bool
+	hexDump bool
+
+	lastActive atomic.Int64 // unix nanoseconds of the last datagram
+}
+
+// NewUDPClient creates a UDP client on conn. It stops once no datagram
+// has been sent or received for idle; zero waits forever.
+func NewUDPClient(conn net.Conn, idle time.Duration, verbose bool) *UDPClient {
+	return &UDPClient{
+		conn:    conn,
+		idle:    idle,
+		verbose: verbose,
+	}
+}
+
+// SetLineMode sends each input line as its own datagram instead of each
+// read.
+func (c *UDPClient) SetLineMode(enable bool) {
+	c.lines = enable
+}
+
+// SetHexDump shows datagrams as hex dumps: received ones on the output,
+// sent ones on stderr.
+func (c *UDPClient) SetHexDump(enable bool) {
+	c.hexDump = enable
+}
+
+// Run relays datagrams between in, out and the peer until the idle
+// timeout expires, an error occurs or the process is interrupted.
+func (c *UDPClient) Run(in io.Reader, out io.Writer) error {
+	c.touch()
+
+	// Handle shutdown signal
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+
+	errCh := make(chan error, 2)
+	go func() {
+		if err := c.send(in); err != nil {
+			errCh <- err
+		}
+	}()
+	go func() {
+		errCh <- c.receive(out)
+	}()
+
+	select {
+	case err := <-errCh:
+		if errors.Is(err, errIdle) {
+			if c.verbose {
+				fmt.Fprintf(os.Stderr, "No datagrams for %v, closing\n", c.idle)
+			}
+			return nil
+		}
+		return err
+	case <-sigCh:
+		if c.verbose {
+			fmt.Fprintln(os.Stderr, "\nInterrupted")
+		}
+		return nil
+	}
+}
+
+// send reads the input and sends it until EOF.
+func (c *UDPClient) send(in io.Reader) error {
+	r := bufio.NewReaderSize(in, 64*1024)
+	buf := make([]byte, 64*1024)
+	for {
+		var data []byte
+		var err error
+		if c.lines {
+			data, err = r.ReadBytes('\n')
+		} else {
+			var n int
+			n, err = r.Read(buf)
+			data = buf[:n]
+		}
+
+		if len(data) > 0 {
+			if c.hexDump {
+				fmt.Fprintf(os.Stderr, "Sending %d bytes to %s:\n%s", len(data), c.conn.RemoteAddr(), hex.Dump(data))
+			} else if c.verbose {
+				fmt.Fprintf(os.Stderr, "Sending %d bytes to %s\n", len(data), c.conn.RemoteAddr())
+			}
+			if _, werr := c.conn.Write(data); werr != nil {
+				return werr
+			}
+			c.touch()
+		}
+
+		if err == io.EOF {
+			if c.verbose {
+				fmt.Fprintln(os.Stderr, "EOF on input, waiting for replies")
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// receive writes incoming datagrams to out until an error occurs or the
+// idle timeout expires.
+func (c *UDPClient) receive(out io.Writer) error {
+	buf := make([]byte, 64*1024)
+	for {
+		if c.idle > 0 {
+			deadline := time.Unix(0, c.lastActive.Load()).Add(c.idle)
+			if err := c.conn.SetReadDeadline(deadline); err != nil {
+				return err
+			}
+		}
+
+		n, err := c.conn.Read(buf)
+		if err != nil {
+			var netErr net.Error
+			if c.idle > 0 && errors.As(err, &netErr) && netErr.Timeout() {
+				// A datagram may have been sent since the deadline was set.
+				if time.Since(time.Unix(0, c.lastActive.Load())) < c.idle {
+					continue
+				}
+				return errIdle
+			}
+			return err
+		}
+		c.touch()
+
+		if c.hexDump {
+			_, err = fmt.Fprintf(out, "Received %d bytes from %s:\n%s", n, c.conn.RemoteAddr(), hex.Dump(buf[:n]))
+		} else {
+			if c.verbose {
+				fmt.Fprintf(os.Stderr, "Received %d bytes from %s\n", n, c.conn.RemoteAddr())
+			}
+			_, err = out.Write(buf[:n])
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (c *UDPClient) touch() {
+	c.lastActive.Store(time.Now().UnixNano())
+}
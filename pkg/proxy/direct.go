@@ -1,29 +1,28 @@
 package proxy
 
 import (
-	"context"
 	"net"
 	"time"
+
+	"github.com/crimson-and-clover/go-connect/pkg/transport"
 )
 
 // DirectDialer implements a direct TCP connection without any proxy.
 type DirectDialer struct {
 	timeout time.Duration
+	opts    transport.DialOptions
 }
 
-// NewDirectDialer creates a new direct dialer with the specified timeout.
-func NewDirectDialer(timeout time.Duration) *DirectDialer {
+// NewDirectDialer creates a new direct dialer with the specified timeout
+// and dial options.
+func NewDirectDialer(timeout time.Duration, opts transport.DialOptions) *DirectDialer {
 	if timeout == 0 {
 		timeout = 30 * time.Second
 	}
-	return &DirectDialer{timeout: timeout}
+	return &DirectDialer{timeout: timeout, opts: opts}
 }
 
 // Dial connects directly to the target address.
 func (d *DirectDialer) Dial(network, address string) (net.Conn, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
-	defer cancel()
-
-	dialer := &net.Dialer{}
-	return dialer.DialContext(ctx, network, address)
+	return d.opts.Dial(network, address, d.timeout)
 }
@@ -28,9 +28,27 @@ func NewHTTPProxy(proxyURL *url.URL, config Config) *HTTPProxy {
 // Dial connects to the target through the HTTP proxy.
 func (p *HTTPProxy) Dial(network, address string) (net.Conn, error) {
 	if network != "tcp" {
-		return nil, fmt.Errorf("unsupported network type: %s", network)
+		return nil, fmt.Errorf("unsupported network type: %s (HTTP proxies only tunnel TCP)", network)
 	}
 
+	targetHost, targetPort, err := net.SplitHostPort(address)
+	if err != nil {
+		// Assume the address includes the default port
+		targetHost = address
+		targetPort = "80"
+	}
+	address = net.JoinHostPort(targetHost, targetPort)
+
+	// --resolve and --connect-to redirect the tunnel; the Host header
+	// keeps the original name.
+	return p.config.tunnel(address, func(connectTarget string) (net.Conn, error) {
+		return p.dialTunnel(address, connectTarget)
+	})
+}
+
+// dialTunnel connects to the proxy and asks it for a tunnel to
+// connectTarget on behalf of address.
+func (p *HTTPProxy) dialTunnel(address, connectTarget string) (net.Conn, error) {
 	// Connect to the proxy server
 	timeout := p.config.Timeout
 	if timeout == 0 {
@@ -46,21 +64,14 @@ func (p *HTTPProxy) Dial(network, address string) (net.Conn, error) {
 		fmt.Fprintf(os.Stderr, "Connecting to HTTP proxy at %s\n", proxyAddr)
 	}
 
-	conn, err := net.DialTimeout("tcp", proxyAddr, timeout)
+	conn, err := NewDirectDialer(timeout, p.config.proxyDial()).Dial("tcp", proxyAddr)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to proxy %s: %w", proxyAddr, err)
 	}
 
 	// Build CONNECT request
-	targetHost, targetPort, err := net.SplitHostPort(address)
-	if err != nil {
-		// Assume the address includes the default port
-		targetHost = address
-		targetPort = "80"
-	}
-
-	req := fmt.Sprintf("CONNECT %s:%s HTTP/1.1\r\n", targetHost, targetPort)
-	req += fmt.Sprintf("Host: %s:%s\r\n", targetHost, targetPort)
+	req := fmt.Sprintf("CONNECT %s HTTP/1.1\r\n", connectTarget)
+	req += fmt.Sprintf("Host: %s\r\n", address)
 	req += "User-Agent: goconnect/1.0\r\n"
 
 	// Add authentication if present
@@ -74,7 +85,7 @@ func (p *HTTPProxy) Dial(network, address string) (net.Conn, error) {
 	req += "\r\n"
 
 	if p.config.Verbose {
-		fmt.Fprintf(os.Stderr, "Sending CONNECT request for %s:%s\n", targetHost, targetPort)
+		fmt.Fprintf(os.Stderr, "Sending CONNECT request for %s\n", connectTarget)
 	}
 
 	// Send the request
@@ -1,7 +1,6 @@
 package proxy
 
 import (
-	"crypto/tls"
 	"encoding/base64"
 	"fmt"
 	"net"
@@ -9,6 +8,8 @@ import (
 	"os"
 	"strings"
 	"time"
+
+	"github.com/crimson-and-clover/go-connect/pkg/transport"
 )
 
 // HTTPSProxy implements HTTP CONNECT over TLS (HTTPS proxy).
@@ -28,7 +29,7 @@ func NewHTTPSProxy(proxyURL *url.URL, config Config) *HTTPSProxy {
 // Dial connects to the target through the HTTPS proxy.
 func (p *HTTPSProxy) Dial(network, address string) (net.Conn, error) {
 	if network != "tcp" {
-		return nil, fmt.Errorf("unsupported network type: %s", network)
+		return nil, fmt.Errorf("unsupported network type: %s (HTTP proxies only tunnel TCP)", network)
 	}
 
 	timeout := p.config.Timeout
@@ -45,27 +46,46 @@ func (p *HTTPSProxy) Dial(network, address string) (net.Conn, error) {
 		fmt.Fprintf(os.Stderr, "Connecting to HTTPS proxy at %s\n", proxyAddr)
 	}
 
-	// First establish TCP connection to proxy
-	plainConn, err := net.DialTimeout("tcp", proxyAddr, timeout)
+	tlsWrapper, err := transport.NewTLSWrapper(transport.TLSOptions{
+		ServerName: p.proxyURL.Hostname(),
+		SkipVerify: !p.config.TLSVerify,
+		Verbose:    p.config.Verbose,
+		CAFile:     p.config.CAFile,
+		CAAppend:   p.config.CAAppend,
+		Pins:       p.config.Pins,
+		KeyLogFile: p.config.KeyLogFile,
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to proxy %s: %w", proxyAddr, err)
+		return nil, err
 	}
 
-	// Wrap with TLS
-	tlsConfig := &tls.Config{
-		ServerName:         p.proxyURL.Hostname(),
-		InsecureSkipVerify: !p.config.TLSVerify,
+	targetHost, targetPort, err := net.SplitHostPort(address)
+	if err != nil {
+		targetHost = address
+		targetPort = "443"
 	}
+	address = net.JoinHostPort(targetHost, targetPort)
 
-	tlsConn := tls.Client(plainConn, tlsConfig)
-	if err := tlsConn.SetDeadline(time.Now().Add(timeout)); err != nil {
-		_ = plainConn.Close()
-		return nil, err
+	// --resolve and --connect-to redirect the tunnel; the Host header
+	// keeps the original name.
+	return p.config.tunnel(address, func(connectTarget string) (net.Conn, error) {
+		return p.dialTunnel(tlsWrapper, proxyAddr, address, connectTarget, timeout)
+	})
+}
+
+// dialTunnel connects to the proxy over TLS and asks it for a tunnel to
+// connectTarget on behalf of address.
+func (p *HTTPSProxy) dialTunnel(tlsWrapper *transport.TLSWrapper, proxyAddr, address, connectTarget string, timeout time.Duration) (net.Conn, error) {
+	// First establish TCP connection to proxy
+	plainConn, err := NewDirectDialer(timeout, p.config.proxyDial()).Dial("tcp", proxyAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to proxy %s: %w", proxyAddr, err)
 	}
 
-	if err := tlsConn.Handshake(); err != nil {
-		_ = tlsConn.Close()
-		return nil, fmt.Errorf("TLS handshake failed: %w", err)
+	// Wrap with TLS
+	tlsConn, err := tlsWrapper.Wrap(plainConn, timeout)
+	if err != nil {
+		return nil, err
 	}
 
 	if p.config.Verbose {
@@ -73,19 +93,13 @@ func (p *HTTPSProxy) Dial(network, address string) (net.Conn, error) {
 	}
 
 	// Now perform HTTP CONNECT through the TLS connection
-	return p.doConnect(tlsConn, address, timeout)
+	return p.doConnect(tlsConn, address, connectTarget, timeout)
 }
 
 // doConnect performs the HTTP CONNECT handshake.
-func (p *HTTPSProxy) doConnect(conn net.Conn, address string, timeout time.Duration) (net.Conn, error) {
-	targetHost, targetPort, err := net.SplitHostPort(address)
-	if err != nil {
-		targetHost = address
-		targetPort = "443"
-	}
-
-	req := fmt.Sprintf("CONNECT %s:%s HTTP/1.1\r\n", targetHost, targetPort)
-	req += fmt.Sprintf("Host: %s:%s\r\n", targetHost, targetPort)
+func (p *HTTPSProxy) doConnect(conn net.Conn, address, connectTarget string, timeout time.Duration) (net.Conn, error) {
+	req := fmt.Sprintf("CONNECT %s HTTP/1.1\r\n", connectTarget)
+	req += fmt.Sprintf("Host: %s\r\n", address)
 	req += "User-Agent: goconnect/1.0\r\n"
 
 	if p.proxyURL.User != nil {
@@ -98,7 +112,7 @@ func (p *HTTPSProxy) doConnect(conn net.Conn, address string, timeout time.Durat
 	req += "\r\n"
 
 	if p.config.Verbose {
-		fmt.Fprintf(os.Stderr, "Sending CONNECT request for %s:%s\n", targetHost, targetPort)
+		fmt.Fprintf(os.Stderr, "Sending CONNECT request for %s\n", connectTarget)
 	}
 
 	if err := conn.SetWriteDeadline(time.Now().Add(timeout)); err != nil {
@@ -2,10 +2,15 @@
 package proxy
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"net/url"
+	"os"
+	"strings"
 	"time"
+
+	"github.com/crimson-and-clover/go-connect/pkg/transport"
 )
 
 // Dialer is the common interface for all proxy types.
@@ -19,6 +24,19 @@ type Config struct {
 	Timeout   time.Duration
 	TLSVerify bool
 	Verbose   bool
+
+	// Trusted CA certificates and SPKI pins for HTTPS proxies
+	// (see transport.TLSOptions).
+	CAFile   string
+	CAAppend bool
+	Pins     []string
+
+	// KeyLogFile receives the HTTPS proxy TLS secrets (NSS key log format).
+	KeyLogFile string
+
+	// Dial controls the connection to the proxy (or the target, when
+	// dialing directly): local binding, address family and Happy Eyeballs.
+	Dial transport.DialOptions
 }
 
 // NewDialer creates a Dialer based on the proxy URL.
@@ -26,7 +44,7 @@ type Config struct {
 // If proxyURL is empty, returns a direct dialer.
 func NewDialer(proxyURL string, config Config) (Dialer, error) {
 	if proxyURL == "" {
-		return NewDirectDialer(config.Timeout), nil
+		return NewDirectDialer(config.Timeout, config.Dial), nil
 	}
 
 	u, err := url.Parse(proxyURL)
@@ -45,3 +63,40 @@ func NewDialer(proxyURL string, config Config) (Dialer, error) {
 		return nil, fmt.Errorf("unsupported proxy scheme: %s", u.Scheme)
 	}
 }
+
+// proxyDial returns the options for connecting to the proxy server. The
+// address overrides only apply to the target reached through it.
+func (c Config) proxyDial() transport.DialOptions {
+	dial := c.Dial
+	dial.Overrides = nil
+	return dial
+}
+
+// tunnel opens a tunnel to address with open, which is given the target
+// to request from the proxy. If --resolve gives several addresses, each
+// is tried in turn over a new proxy connection.
+func (c Config) tunnel(address string, open func(target string) (net.Conn, error)) (net.Conn, error) {
+	targets := c.Dial.Overrides.Rewrite(address)
+	if len(targets) == 1 {
+		return open(targets[0])
+	}
+
+	if c.Verbose {
+		fmt.Fprintf(os.Stderr, "Redirecting %s to %s\n", address, strings.Join(targets, ", "))
+	}
+	var errs []error
+	for _, target := range targets {
+		if c.Verbose {
+			fmt.Fprintf(os.Stderr, "Trying %s...\n", target)
+		}
+		conn, err := open(target)
+		if err == nil {
+			return conn, nil
+		}
+		if c.Verbose {
+			fmt.Fprintf(os.Stderr, "  %v\n", err)
+		}
+		errs = append(errs, err)
+	}
+	return nil, fmt.Errorf("no address of %s accepted the tunnel (%d tried): %w", address, len(targets), errors.Join(errs...))
+}
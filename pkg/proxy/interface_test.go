@@ -0,0 +1,53 @@
+package proxy
+
+import (
+	"errors"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/crimson-and-clover/go-connect/pkg/transport"
+)
+
+func TestConfigTunnelTriesTargetsInOrder(t *testing.T) {
+	overrides, err := transport.NewAddressOverrides([]string{"a.example:443:192.0.2.1,192.0.2.2,192.0.2.3"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := Config{Dial: transport.DialOptions{Overrides: overrides}}
+	want := []string{"192.0.2.1:443", "192.0.2.2:443", "192.0.2.3:443"}
+
+	// Only the last target accepts the tunnel.
+	var tried []string
+	conn, err := c.tunnel("a.example:443", func(target string) (net.Conn, error) {
+		tried = append(tried, target)
+		if target != want[2] {
+			return nil, errors.New("refused")
+		}
+		client, server := net.Pipe()
+		_ = server.Close()
+		return client, nil
+	})
+	if err != nil {
+		t.Fatalf("tunnel: %v", err)
+	}
+	_ = conn.Close()
+	if !slices.Equal(tried, want) {
+		t.Errorf("tried %v, want %v", tried, want)
+	}
+
+	// When every target fails, all of them are reported.
+	refused := errors.New("refused")
+	tried = nil
+	_, err = c.tunnel("a.example:443", func(target string) (net.Conn, error) {
+		tried = append(tried, target)
+		return nil, refused
+	})
+	if err == nil || !errors.Is(err, refused) || !strings.Contains(err.Error(), "3 tried") {
+		t.Errorf("error = %v, want all 3 targets refused", err)
+	}
+	if !slices.Equal(tried, want) {
+		t.Errorf("tried %v, want %v", tried, want)
+	}
+}
@@ -1,46 +1,428 @@
 package proxy
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+// SOCKS5 protocol constants (RFC 1928, RFC 1929).
+const (
+	socks5Version = 0x05
+
+	socks5AuthNone         = 0x00
+	socks5AuthPassword     = 0x02
+	socks5AuthNoAcceptable = 0xff
+
+	socks5PasswordVersion = 0x01
+
+	socks5CmdConnect      = 0x01
+	socks5CmdUDPAssociate = 0x03
 
-	"golang.org/x/net/proxy"
+	socks5AddrIPv4   = 0x01
+	socks5AddrDomain = 0x03
+	socks5AddrIPv6   = 0x04
 )
 
-// SOCKS5Proxy implements SOCKS5 proxy support using golang.org/x/net/proxy.
+// Errors returned for each SOCKS5 reply code defined in RFC 1928.
+var (
+	ErrSOCKS5GeneralFailure          = errors.New("general SOCKS server failure")
+	ErrSOCKS5ConnectionNotAllowed    = errors.New("connection not allowed by ruleset")
+	ErrSOCKS5NetworkUnreachable      = errors.New("network unreachable")
+	ErrSOCKS5HostUnreachable         = errors.New("host unreachable")
+	ErrSOCKS5ConnectionRefused       = errors.New("connection refused")
+	ErrSOCKS5TTLExpired              = errors.New("TTL expired")
+	ErrSOCKS5CommandNotSupported     = errors.New("command not supported")
+	ErrSOCKS5AddressTypeNotSupported = errors.New("address type not supported")
+)
+
+var socks5ReplyErrors = map[byte]error{
+	0x01: ErrSOCKS5GeneralFailure,
+	0x02: ErrSOCKS5ConnectionNotAllowed,
+	0x03: ErrSOCKS5NetworkUnreachable,
+	0x04: ErrSOCKS5HostUnreachable,
+	0x05: ErrSOCKS5ConnectionRefused,
+	0x06: ErrSOCKS5TTLExpired,
+	0x07: ErrSOCKS5CommandNotSupported,
+	0x08: ErrSOCKS5AddressTypeNotSupported,
+}
+
+// SOCKS5ReplyError is returned when the SOCKS5 server answers a request
+// with a non-success reply code. It unwraps to one of the ErrSOCKS5*
+// values when the code is defined by RFC 1928.
+type SOCKS5ReplyError struct {
+	Code byte
+}
+
+func (e *SOCKS5ReplyError) Error() string {
+	if err, ok := socks5ReplyErrors[e.Code]; ok {
+		return fmt.Sprintf("SOCKS5 reply 0x%02x: %v", e.Code, err)
+	}
+	return fmt.Sprintf("SOCKS5 reply 0x%02x: unassigned reply code", e.Code)
+}
+
+// Unwrap returns the error for the reply code, if it is a known one.
+func (e *SOCKS5ReplyError) Unwrap() error {
+	return socks5ReplyErrors[e.Code]
+}
+
+// SOCKS5Proxy implements a SOCKS5 client (RFC 1928) with optional
+// username/password authentication (RFC 1929).
 type SOCKS5Proxy struct {
-	dialer proxy.Dialer
-	config Config
+	proxyURL *url.URL
+	config   Config
+	forward  Dialer
 }
 
 // NewSOCKS5Proxy creates a new SOCKS5 proxy dialer.
 func NewSOCKS5Proxy(proxyURL *url.URL, config Config) (*SOCKS5Proxy, error) {
-	var auth *proxy.Auth
+	if proxyURL.Host == "" {
+		return nil, fmt.Errorf("missing SOCKS5 proxy address")
+	}
+
 	if proxyURL.User != nil {
+		username := proxyURL.User.Username()
 		password, _ := proxyURL.User.Password()
-		auth = &proxy.Auth{
-			User:     proxyURL.User.Username(),
-			Password: password,
+		if len(username) == 0 || len(username) > 255 || len(password) > 255 {
+			return nil, fmt.Errorf("invalid SOCKS5 credentials: username and password must be 1-255 bytes")
 		}
 	}
 
-	dialer, err := proxy.SOCKS5("tcp", proxyURL.Host, auth, proxy.Direct)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create SOCKS5 dialer: %w", err)
-	}
-
 	return &SOCKS5Proxy{
-		dialer: dialer,
-		config: config,
+		proxyURL: proxyURL,
+		config:   config,
+		forward:  NewDirectDialer(config.Timeout, config.proxyDial()),
 	}, nil
 }
 
-// Dial connects to the target through the SOCKS5 proxy.
+// SetForward sets the dialer used to reach the SOCKS5 server itself.
+// By default a direct dialer is used.
+func (p *SOCKS5Proxy) SetForward(forward Dialer) {
+	p.forward = forward
+}
+
+// Dial connects to the target through the SOCKS5 proxy. For "udp", the
+// datagrams are relayed through a UDP association.
 func (p *SOCKS5Proxy) Dial(network, address string) (net.Conn, error) {
-	if network != "tcp" {
-		return nil, fmt.Errorf("SOCKS5 only supports TCP, got: %s", network)
+	switch network {
+	case "tcp":
+	case "udp":
+		return p.dialUDP(address)
+	default:
+		return nil, fmt.Errorf("SOCKS5 only supports TCP and UDP, got: %s", network)
+	}
+
+	// --resolve and --connect-to redirect the tunnel.
+	return p.config.tunnel(address, func(target string) (net.Conn, error) {
+		return p.dialTunnel(address, target)
+	})
+}
+
+// dialTunnel connects to the SOCKS5 server and asks it for a tunnel to
+// target on behalf of address.
+func (p *SOCKS5Proxy) dialTunnel(address, target string) (net.Conn, error) {
+	timeout := p.timeout()
+	conn, err := p.open(timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.connect(conn, target, timeout); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	if p.config.Verbose {
+		fmt.Fprintf(os.Stderr, "Tunnel established to %s\n", address)
+	}
+
+	// Reset deadline
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+func (p *SOCKS5Proxy) timeout() time.Duration {
+	if p.config.Timeout == 0 {
+		return 30 * time.Second
+	}
+	return p.config.Timeout
+}
+
+// address returns the host:port of the SOCKS5 server.
+func (p *SOCKS5Proxy) address() string {
+	port := p.proxyURL.Port()
+	if port == "" {
+		port = "1080" // Default SOCKS port
+	}
+	return net.JoinHostPort(p.proxyURL.Hostname(), port)
+}
+
+// open connects to the SOCKS5 server and negotiates authentication.
+func (p *SOCKS5Proxy) open(timeout time.Duration) (net.Conn, error) {
+	proxyAddr := p.address()
+
+	if p.config.Verbose {
+		fmt.Fprintf(os.Stderr, "Connecting to SOCKS5 proxy at %s\n", proxyAddr)
+	}
+
+	conn, err := p.forward.Dial("tcp", proxyAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to proxy %s: %w", proxyAddr, err)
+	}
+
+	if err := p.negotiate(conn, timeout); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// negotiate performs the method selection greeting and, if requested by
+// the server, username/password authentication.
+func (p *SOCKS5Proxy) negotiate(conn net.Conn, timeout time.Duration) error {
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+
+	methods := []byte{socks5AuthNone}
+	if p.proxyURL.User != nil {
+		methods = append(methods, socks5AuthPassword)
+	}
+
+	if p.config.Verbose {
+		fmt.Fprintf(os.Stderr, "SOCKS5 greeting: offering methods %v\n", methodNames(methods))
+	}
+
+	greeting := append([]byte{socks5Version, byte(len(methods))}, methods...)
+	if _, err := conn.Write(greeting); err != nil {
+		return fmt.Errorf("failed to send SOCKS5 greeting: %w", err)
+	}
+
+	resp := make([]byte, 2)
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return fmt.Errorf("failed to read SOCKS5 greeting response: %w", err)
+	}
+	if resp[0] != socks5Version {
+		return fmt.Errorf("unexpected SOCKS version in greeting response: %d", resp[0])
+	}
+
+	if p.config.Verbose {
+		fmt.Fprintf(os.Stderr, "SOCKS5 server selected method: %s\n", methodName(resp[1]))
+	}
+
+	switch resp[1] {
+	case socks5AuthNone:
+		return nil
+	case socks5AuthPassword:
+		if p.proxyURL.User == nil {
+			return fmt.Errorf("SOCKS5 server selected password authentication, which was not offered")
+		}
+		return p.authenticate(conn, timeout)
+	case socks5AuthNoAcceptable:
+		return fmt.Errorf("SOCKS5 server accepted none of the offered authentication methods")
+	default:
+		return fmt.Errorf("SOCKS5 server selected unsupported method 0x%02x", resp[1])
+	}
+}
+
+// authenticate performs username/password authentication (RFC 1929).
+func (p *SOCKS5Proxy) authenticate(conn net.Conn, timeout time.Duration) error {
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+
+	username := p.proxyURL.User.Username()
+	password, _ := p.proxyURL.User.Password()
+
+	if p.config.Verbose {
+		fmt.Fprintf(os.Stderr, "SOCKS5 authenticating as %q\n", username)
+	}
+
+	req := []byte{socks5PasswordVersion, byte(len(username))}
+	req = append(req, username...)
+	req = append(req, byte(len(password)))
+	req = append(req, password...)
+	if _, err := conn.Write(req); err != nil {
+		return fmt.Errorf("failed to send SOCKS5 authentication: %w", err)
+	}
+
+	resp := make([]byte, 2)
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return fmt.Errorf("failed to read SOCKS5 authentication response: %w", err)
+	}
+	if resp[0] != socks5PasswordVersion {
+		return fmt.Errorf("unexpected version in SOCKS5 authentication response: %d", resp[0])
+	}
+	if resp[1] != 0x00 {
+		return fmt.Errorf("SOCKS5 authentication failed (status 0x%02x)", resp[1])
+	}
+
+	if p.config.Verbose {
+		fmt.Fprintf(os.Stderr, "SOCKS5 authentication succeeded\n")
+	}
+
+	return nil
+}
+
+// connect sends the CONNECT request and reads the server reply.
+func (p *SOCKS5Proxy) connect(conn net.Conn, address string, timeout time.Duration) error {
+	_, err := p.request(conn, socks5CmdConnect, address, timeout)
+	return err
+}
+
+// request sends a request with the given command and returns the bound
+// address from the server reply.
+func (p *SOCKS5Proxy) request(conn net.Conn, cmd byte, address string, timeout time.Duration) (string, error) {
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return "", err
+	}
+
+	req, err := appendSOCKS5Addr([]byte{socks5Version, cmd, 0x00}, address)
+	if err != nil {
+		return "", err
+	}
+
+	if p.config.Verbose {
+		fmt.Fprintf(os.Stderr, "Sending SOCKS5 %s request for %s\n", commandName(cmd), address)
+	}
+
+	if _, err := conn.Write(req); err != nil {
+		return "", fmt.Errorf("failed to send SOCKS5 %s request: %w", commandName(cmd), err)
+	}
+
+	// VER, REP, RSV, ATYP
+	resp := make([]byte, 4)
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return "", fmt.Errorf("failed to read SOCKS5 reply: %w", err)
+	}
+	if resp[0] != socks5Version {
+		return "", fmt.Errorf("unexpected SOCKS version in reply: %d", resp[0])
+	}
+
+	if p.config.Verbose {
+		fmt.Fprintf(os.Stderr, "SOCKS5 reply: 0x%02x\n", resp[1])
+	}
+
+	if resp[1] != 0x00 {
+		return "", &SOCKS5ReplyError{Code: resp[1]}
 	}
 
-	return p.dialer.Dial(network, address)
+	bound, err := readSOCKS5Addr(conn, resp[3])
+	if err != nil {
+		return "", fmt.Errorf("failed to read SOCKS5 bound address: %w", err)
+	}
+
+	if p.config.Verbose {
+		fmt.Fprintf(os.Stderr, "SOCKS5 bound address: %s\n", bound)
+	}
+
+	return bound, nil
+}
+
+// appendSOCKS5Addr appends address as an ATYP, DST.ADDR, DST.PORT triple.
+func appendSOCKS5Addr(b []byte, address string) ([]byte, error) {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid target address %s: %w", address, err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 0 || port > 65535 {
+		return nil, fmt.Errorf("invalid target port: %s", portStr)
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			b = append(b, socks5AddrIPv4)
+			b = append(b, ip4...)
+		} else {
+			b = append(b, socks5AddrIPv6)
+			b = append(b, ip.To16()...)
+		}
+	} else {
+		if len(host) > 255 {
+			return nil, fmt.Errorf("target hostname too long: %s", host)
+		}
+		b = append(b, socks5AddrDomain, byte(len(host)))
+		b = append(b, host...)
+	}
+	return append(b, byte(port>>8), byte(port)), nil
+}
+
+// readSOCKS5Addr reads a BND.ADDR/BND.PORT pair of the given address type.
+func readSOCKS5Addr(r io.Reader, atyp byte) (string, error) {
+	var host string
+	switch atyp {
+	case socks5AddrIPv4:
+		buf := make([]byte, net.IPv4len)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return "", err
+		}
+		host = net.IP(buf).String()
+	case socks5AddrIPv6:
+		buf := make([]byte, net.IPv6len)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return "", err
+		}
+		host = net.IP(buf).String()
+	case socks5AddrDomain:
+		l := make([]byte, 1)
+		if _, err := io.ReadFull(r, l); err != nil {
+			return "", err
+		}
+		buf := make([]byte, l[0])
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return "", err
+		}
+		host = string(buf)
+	default:
+		return "", fmt.Errorf("unknown address type 0x%02x", atyp)
+	}
+
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(r, port); err != nil {
+		return "", err
+	}
+
+	return net.JoinHostPort(host, strconv.Itoa(int(port[0])<<8|int(port[1]))), nil
+}
+
+func commandName(cmd byte) string {
+	switch cmd {
+	case socks5CmdConnect:
+		return "CONNECT"
+	case socks5CmdUDPAssociate:
+		return "UDP ASSOCIATE"
+	default:
+		return fmt.Sprintf("0x%02x", cmd)
+	}
+}
+
+func methodName(method byte) string {
+	switch method {
+	case socks5AuthNone:
+		return "no authentication"
+	case socks5AuthPassword:
+		return "username/password"
+	case socks5AuthNoAcceptable:
+		return "no acceptable methods"
+	default:
+		return fmt.Sprintf("0x%02x", method)
+	}
+}
+
+func methodNames(methods []byte) []string {
+	names := make([]string, 0, len(methods))
+	for _, m := range methods {
+		names = append(names, methodName(m))
+	}
+	return names
 }
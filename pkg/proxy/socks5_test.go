@@ -0,0 +1,282 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// socks5TestServer is a minimal SOCKS5 server that answers every CONNECT
+// request with reply and, on success, echoes the tunnel.
+type socks5TestServer struct {
+	user, pass  string // required credentials, if any
+	authVersion byte   // version byte of the authentication reply
+	reply       byte
+
+	requests chan string
+}
+
+// start serves on a loopback port and returns a proxy URL for it.
+func (s *socks5TestServer) start(t *testing.T, userinfo *url.Userinfo) *url.URL {
+	t.Helper()
+
+	if s.authVersion == 0 {
+		s.authVersion = socks5PasswordVersion
+	}
+	s.requests = make(chan string, 16)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() { _ = conn.Close() }()
+				s.serve(conn)
+			}()
+		}
+	}()
+
+	return &url.URL{Scheme: "socks5", Host: ln.Addr().String(), User: userinfo}
+}
+
+// serve handles one client: greeting, authentication and one request.
+func (s *socks5TestServer) serve(conn net.Conn) {
+	head := make([]byte, 2)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return
+	}
+	methods := make([]byte, head[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return
+	}
+
+	if s.user == "" {
+		_, _ = conn.Write([]byte{socks5Version, socks5AuthNone})
+	} else {
+		if !bytes.Contains(methods, []byte{socks5AuthPassword}) {
+			_, _ = conn.Write([]byte{socks5Version, socks5AuthNoAcceptable})
+			return
+		}
+		_, _ = conn.Write([]byte{socks5Version, socks5AuthPassword})
+
+		user, pass, ok := readCredentials(conn)
+		if !ok {
+			return
+		}
+		status := byte(0x00)
+		if user != s.user || pass != s.pass {
+			status = 0x01
+		}
+		_, _ = conn.Write([]byte{s.authVersion, status})
+		if status != 0x00 {
+			return
+		}
+	}
+
+	req := make([]byte, 4)
+	if _, err := io.ReadFull(conn, req); err != nil {
+		return
+	}
+	target, err := readSOCKS5Addr(conn, req[3])
+	if err != nil {
+		return
+	}
+	s.requests <- target
+
+	_, _ = conn.Write([]byte{socks5Version, s.reply, 0x00, socks5AddrIPv4, 127, 0, 0, 1, 0x1f, 0x90})
+	if s.reply == 0x00 {
+		_, _ = io.Copy(conn, conn)
+	}
+}
+
+// readCredentials reads an RFC 1929 username/password request.
+func readCredentials(r io.Reader) (string, string, bool) {
+	field := func() (string, bool) {
+		l := make([]byte, 1)
+		if _, err := io.ReadFull(r, l); err != nil {
+			return "", false
+		}
+		b := make([]byte, l[0])
+		_, err := io.ReadFull(r, b)
+		return string(b), err == nil
+	}
+
+	version := make([]byte, 1)
+	if _, err := io.ReadFull(r, version); err != nil || version[0] != socks5PasswordVersion {
+		return "", "", false
+	}
+	user, ok := field()
+	if !ok {
+		return "", "", false
+	}
+	pass, ok := field()
+	return user, pass, ok
+}
+
+// dialSOCKS5 connects to address through the proxy.
+func dialSOCKS5(t *testing.T, proxyURL *url.URL, address string) (net.Conn, error) {
+	t.Helper()
+
+	p, err := NewSOCKS5Proxy(proxyURL, Config{Timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p.Dial("tcp", address)
+}
+
+func TestSOCKS5Connect(t *testing.T) {
+	server := &socks5TestServer{}
+	proxyURL := server.start(t, nil)
+
+	conn, err := dialSOCKS5(t, proxyURL, "example.test:80")
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	if got := <-server.requests; got != "example.test:80" {
+		t.Errorf("requested %s, want example.test:80", got)
+	}
+
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Errorf("tunnel echoed %q, %v", buf, err)
+	}
+}
+
+func TestSOCKS5Authentication(t *testing.T) {
+	tests := []struct {
+		name        string
+		userinfo    *url.Userinfo
+		authVersion byte
+		wantErr     string
+	}{
+		{"success", url.UserPassword("alice", "secret"), 0, ""},
+		{"wrong password", url.UserPassword("alice", "guess"), 0, "authentication failed"},
+		{"no credentials", nil, 0, "accepted none of the offered authentication methods"},
+		{"bad reply version", url.UserPassword("alice", "secret"), socks5Version, "unexpected version"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := &socks5TestServer{user: "alice", pass: "secret", authVersion: tt.authVersion}
+			proxyURL := server.start(t, tt.userinfo)
+
+			conn, err := dialSOCKS5(t, proxyURL, "example.test:80")
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Dial: %v", err)
+				}
+				_ = conn.Close()
+				return
+			}
+			if err == nil {
+				_ = conn.Close()
+				t.Fatalf("Dial succeeded, want %q", tt.wantErr)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSOCKS5ReplyCodes(t *testing.T) {
+	for code := byte(0x01); code <= 0x09; code++ {
+		server := &socks5TestServer{reply: code}
+		proxyURL := server.start(t, nil)
+
+		conn, err := dialSOCKS5(t, proxyURL, "192.0.2.1:80")
+		if err == nil {
+			_ = conn.Close()
+			t.Errorf("reply 0x%02x: Dial succeeded", code)
+			continue
+		}
+
+		var replyErr *SOCKS5ReplyError
+		if !errors.As(err, &replyErr) || replyErr.Code != code {
+			t.Errorf("reply 0x%02x: error = %v, want a SOCKS5ReplyError", code, err)
+			continue
+		}
+		if want := socks5ReplyErrors[code]; want != nil && !errors.Is(err, want) {
+			t.Errorf("reply 0x%02x: error does not match %v", code, want)
+		}
+		if code == 0x09 && errors.Unwrap(replyErr) != nil {
+			t.Errorf("unassigned reply 0x09 unwraps to %v", errors.Unwrap(replyErr))
+		}
+	}
+}
+
+func TestSOCKS5ProxyAddress(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"socks5://proxy.example", "proxy.example:1080"},
+		{"socks5://proxy.example:9050", "proxy.example:9050"},
+		{"socks5://[::1]", "[::1]:1080"},
+		{"socks5://[::1]:9050", "[::1]:9050"},
+		{"socks5h://192.0.2.1", "192.0.2.1:1080"},
+	}
+
+	for _, tt := range tests {
+		u, err := url.Parse(tt.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := NewSOCKS5Proxy(u, Config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := p.address(); got != tt.want {
+			t.Errorf("%s: address = %s, want %s", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestParseSOCKS5UDP(t *testing.T) {
+	ipv4 := []byte{0x00, 0x00, 0x00, socks5AddrIPv4, 192, 0, 2, 1, 0x00, 0x35}
+	domain := append([]byte{0x00, 0x00, 0x00, socks5AddrDomain, 4}, "host"...)
+	domain = append(domain, 0x00, 0x35)
+
+	tests := []struct {
+		name    string
+		packet  []byte
+		payload string
+		ok      bool
+	}{
+		{"ipv4", append(ipv4, "data"...), "data", true},
+		{"domain", append(domain, "data"...), "data", true},
+		{"empty payload", ipv4, "", true},
+		{"fragment", append([]byte{0x00, 0x00, 0x01}, append(ipv4[3:], "data"...)...), "", false},
+		{"short header", []byte{0x00, 0x00, 0x00}, "", false},
+		{"truncated address", ipv4[:6], "", false},
+		{"missing port", ipv4[:8], "", false},
+		{"truncated domain", domain[:7], "", false},
+		{"unknown address type", []byte{0x00, 0x00, 0x00, 0x02, 1, 2, 3, 4, 0, 53}, "", false},
+	}
+
+	for _, tt := range tests {
+		payload, ok := parseSOCKS5UDP(tt.packet)
+		if ok != tt.ok || string(payload) != tt.payload {
+			t.Errorf("%s: got (%q, %v), want (%q, %v)", tt.name, payload, ok, tt.payload, tt.ok)
+		}
+	}
+}
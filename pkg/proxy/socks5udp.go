@@ -0,0 +1,140 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// dialUDP sets up a UDP association (RFC 1928, section 7) and returns a
+// connection whose reads and writes are single datagrams from and to
+// address, relayed by the SOCKS5 server.
+func (p *SOCKS5Proxy) dialUDP(address string) (net.Conn, error) {
+	// --resolve and --connect-to redirect the datagrams. An association
+	// cannot tell whether a target is reachable, so only one is allowed.
+	targets := p.config.Dial.Overrides.Rewrite(address)
+	if len(targets) > 1 {
+		return nil, fmt.Errorf("--resolve gives %d addresses for %s; UDP through a SOCKS5 proxy needs exactly one", len(targets), address)
+	}
+	target := targets[0]
+
+	// RSV, FRAG, then the destination of every datagram
+	header, err := appendSOCKS5Addr([]byte{0x00, 0x00, 0x00}, target)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := p.timeout()
+	ctrl, err := p.open(timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	// The local relay address is not known yet; zeros let the server
+	// accept our datagrams from any address.
+	relay, err := p.request(ctrl, socks5CmdUDPAssociate, "0.0.0.0:0", timeout)
+	if err != nil {
+		_ = ctrl.Close()
+		return nil, err
+	}
+
+	// Servers commonly answer with an unspecified address, meaning the
+	// one the request was sent to.
+	relayHost, relayPort, err := net.SplitHostPort(relay)
+	if err != nil {
+		_ = ctrl.Close()
+		return nil, err
+	}
+	if ip := net.ParseIP(relayHost); ip != nil && ip.IsUnspecified() {
+		relay = net.JoinHostPort(p.proxyURL.Hostname(), relayPort)
+	}
+
+	if p.config.Verbose {
+		fmt.Fprintf(os.Stderr, "Relaying datagrams to %s via %s\n", address, relay)
+	}
+
+	conn, err := p.forward.Dial("udp", relay)
+	if err != nil {
+		_ = ctrl.Close()
+		return nil, fmt.Errorf("failed to reach SOCKS5 UDP relay %s: %w", relay, err)
+	}
+
+	if err := ctrl.SetDeadline(time.Time{}); err != nil {
+		_ = ctrl.Close()
+		_ = conn.Close()
+		return nil, err
+	}
+
+	c := &socks5UDPConn{
+		Conn:   conn,
+		ctrl:   ctrl,
+		header: header,
+		buf:    make([]byte, 64*1024),
+	}
+
+	// The association ends when the server closes the TCP connection.
+	go func() {
+		_, _ = io.Copy(io.Discard, ctrl)
+		_ = c.Close()
+	}()
+
+	return c, nil
+}
+
+// socks5UDPConn sends and receives datagrams through a SOCKS5 UDP relay,
+// adding and removing the SOCKS5 UDP request header.
+type socks5UDPConn struct {
+	net.Conn // UDP socket connected to the relay
+	ctrl     net.Conn
+	header   []byte
+	buf      []byte
+	once     sync.Once
+}
+
+func (c *socks5UDPConn) Write(b []byte) (int, error) {
+	packet := append(c.header[:len(c.header):len(c.header)], b...)
+	if _, err := c.Conn.Write(packet); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Read returns the payload of the next datagram, dropping fragments and
+// malformed datagrams.
+func (c *socks5UDPConn) Read(b []byte) (int, error) {
+	for {
+		n, err := c.Conn.Read(c.buf)
+		if err != nil {
+			return 0, err
+		}
+		if payload, ok := parseSOCKS5UDP(c.buf[:n]); ok {
+			return copy(b, payload), nil
+		}
+	}
+}
+
+func (c *socks5UDPConn) Close() error {
+	var err error
+	c.once.Do(func() {
+		_ = c.ctrl.Close()
+		err = c.Conn.Close()
+	})
+	return err
+}
+
+// parseSOCKS5UDP returns the payload of a relayed datagram. Fragmented
+// datagrams (FRAG != 0) are not supported.
+func parseSOCKS5UDP(packet []byte) ([]byte, bool) {
+	if len(packet) < 4 || packet[2] != 0x00 {
+		return nil, false
+	}
+	r := bytes.NewReader(packet[4:])
+	if _, err := readSOCKS5Addr(r, packet[3]); err != nil {
+		return nil, false
+	}
+	return packet[len(packet)-r.Len():], true
+}
@@ -0,0 +1,26 @@
+package transport
+
+import "syscall"
+
+// controlBind binds the socket to a network interface and, for a fixed
+// source port, allows reusing it while earlier connections linger in
+// TIME_WAIT.
+func controlBind(c syscall.RawConn, iface string, reuse bool) error {
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		if reuse {
+			if sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1); sockErr != nil {
+				return
+			}
+		}
+		if iface != "" {
+			if sockErr = syscall.BindToDevice(int(fd), iface); sockErr != nil {
+				sockErr = &bindError{iface: iface, err: sockErr}
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package transport
+
+import (
+	"errors"
+	"syscall"
+)
+
+// controlBind binds the socket to a network interface, which is only
+// supported on Linux.
+func controlBind(_ syscall.RawConn, iface string, _ bool) error {
+	if iface != "" {
+		return &bindError{iface: iface, err: errors.New("not supported on this platform")}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package transport
+
+import (
+	"crypto/x509"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LoadCertPool builds a certificate pool from a PEM file or a directory of
+// PEM files. If appendSystem is true, the certificates extend the system
+// roots instead of replacing them.
+func LoadCertPool(path string, appendSystem bool) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+	if appendSystem {
+		systemPool, err := x509.SystemCertPool()
+		if err != nil {
+			return nil, fmt.Errorf("failed to load system roots: %w", err)
+		}
+		pool = systemPool
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA certificates: %w", err)
+	}
+
+	files := []string{path}
+	if info.IsDir() {
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA directory: %w", err)
+		}
+		files = files[:0]
+		for _, e := range entries {
+			if e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+				continue
+			}
+			files = append(files, filepath.Join(path, e.Name()))
+		}
+	}
+
+	loaded := 0
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA certificates: %w", err)
+		}
+		// Files without PEM certificates are skipped in directories
+		// (e.g. OpenSSL hash links to CRLs or stray notes).
+		if pool.AppendCertsFromPEM(data) {
+			loaded++
+		}
+	}
+
+	if loaded == 0 {
+		return nil, fmt.Errorf("no PEM certificates found in %s", path)
+	}
+
+	return pool, nil
+}
+
+// describeRoots describes the trust anchors used for verification.
+func describeRoots(caFile string, appendSystem bool) string {
+	switch {
+	case caFile == "":
+		return "system roots"
+	case appendSystem:
+		return "system roots + " + caFile
+	default:
+		return caFile
+	}
+}
+
+// describeChain formats a certificate chain, one certificate per line.
+func describeChain(chain []*x509.Certificate) string {
+	var b strings.Builder
+	for i, cert := range chain {
+		fmt.Fprintf(&b, "  %d s:%s\n    i:%s\n", i, cert.Subject, cert.Issuer)
+	}
+	return b.String()
+}
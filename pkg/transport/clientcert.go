@@ -0,0 +1,176 @@
+package transport
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// CertificateLoader loads a client certificate from disk and reloads it
+// whenever the certificate or key file changes.
+//
+// The certificate file may be PEM (optionally bundling the key) or
+// PKCS#12. The key file may be a plain or encrypted PEM private key.
+type CertificateLoader struct {
+	certFile string
+	keyFile  string
+	password string
+
+	mu       sync.Mutex
+	cert     *tls.Certificate
+	certTime time.Time
+	keyTime  time.Time
+}
+
+// NewCertificateLoader creates a loader and performs the initial load so
+// that configuration errors surface before any connection is made.
+func NewCertificateLoader(certFile, keyFile, password string) (*CertificateLoader, error) {
+	l := &CertificateLoader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		password: password,
+	}
+	if _, err := l.Certificate(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Certificate returns the current certificate, reloading it if either
+// file has been modified since the last load.
+func (l *CertificateLoader) Certificate() (*tls.Certificate, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	certTime, err := modTime(l.certFile)
+	if err != nil {
+		return nil, err
+	}
+	var keyTime time.Time
+	if l.keyFile != "" {
+		if keyTime, err = modTime(l.keyFile); err != nil {
+			return nil, err
+		}
+	}
+
+	if l.cert != nil && certTime.Equal(l.certTime) && keyTime.Equal(l.keyTime) {
+		return l.cert, nil
+	}
+
+	cert, err := l.load()
+	if err != nil {
+		return nil, err
+	}
+
+	l.cert = cert
+	l.certTime = certTime
+	l.keyTime = keyTime
+	return cert, nil
+}
+
+// load reads and parses the certificate and key files.
+func (l *CertificateLoader) load() (*tls.Certificate, error) {
+	certData, err := os.ReadFile(l.certFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate: %w", err)
+	}
+
+	// Anything without a PEM block is treated as PKCS#12.
+	if block, _ := pem.Decode(certData); block == nil {
+		if l.keyFile != "" {
+			return nil, errors.New("a PKCS#12 certificate contains its private key; a separate key file cannot be used with it")
+		}
+		return loadPKCS12(certData, l.password)
+	}
+
+	keyData := certData
+	if l.keyFile != "" {
+		if keyData, err = os.ReadFile(l.keyFile); err != nil {
+			return nil, fmt.Errorf("failed to read private key: %w", err)
+		}
+	}
+
+	keyPEM, err := decryptKeyPEM(keyData, l.password)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := tls.X509KeyPair(certData, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load client certificate: %w", err)
+	}
+	return &cert, nil
+}
+
+// loadPKCS12 decodes a PKCS#12 bundle into a certificate chain and key.
+func loadPKCS12(data []byte, password string) (*tls.Certificate, error) {
+	key, leaf, caCerts, err := pkcs12.DecodeChain(data, password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode PKCS#12 certificate: %w", err)
+	}
+
+	cert := &tls.Certificate{
+		Certificate: [][]byte{leaf.Raw},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}
+	for _, ca := range caCerts {
+		cert.Certificate = append(cert.Certificate, ca.Raw)
+	}
+	return cert, nil
+}
+
+// decryptKeyPEM returns the private key blocks from data as PEM, decrypting
+// encrypted PKCS#8 and legacy encrypted PEM blocks with password.
+func decryptKeyPEM(data []byte, password string) ([]byte, error) {
+	var out []byte
+	for rest := data; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+
+		if block.Type == "ENCRYPTED PRIVATE KEY" {
+			if password == "" {
+				return nil, errors.New("private key is encrypted; a password is required")
+			}
+			der, err := decryptPKCS8(block.Bytes, password)
+			if err != nil {
+				return nil, err
+			}
+			block = &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+		}
+
+		//nolint:staticcheck // Legacy encrypted PEM keys are still common.
+		if x509.IsEncryptedPEMBlock(block) {
+			if password == "" {
+				return nil, errors.New("private key is encrypted; a password is required")
+			}
+			//nolint:staticcheck // See above.
+			der, err := x509.DecryptPEMBlock(block, []byte(password))
+			if err != nil {
+				return nil, fmt.Errorf("failed to decrypt private key: %w", err)
+			}
+			block = &pem.Block{Type: block.Type, Bytes: der}
+		}
+
+		out = append(out, pem.EncodeToMemory(block)...)
+	}
+	return out, nil
+}
+
+func modTime(path string) (time.Time, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
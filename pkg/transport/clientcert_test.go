@@ -0,0 +1,109 @@
+package transport
+
+import (
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// The keys in testdata were encrypted by openssl with this password.
+const testKeyPassword = "test-password"
+
+func TestDecryptKeyPEM(t *testing.T) {
+	plain, err := os.ReadFile("testdata/key.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := parseTestKey(t, plain)
+
+	tests := []struct {
+		file     string
+		password string
+		wantErr  string
+	}{
+		{"key.pem", "", ""},
+		{"key-aes256.pem", testKeyPassword, ""},
+		{"key-des3.pem", testKeyPassword, ""},
+		{"key-scrypt.pem", testKeyPassword, ""},
+		{"key-aes256.pem", "wrong", "incorrect password"},
+		{"key-des3.pem", "wrong", "incorrect password"},
+		{"key-aes256.pem", "", "a password is required"},
+	}
+
+	for _, tt := range tests {
+		data, err := os.ReadFile(filepath.Join("testdata", tt.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		out, err := decryptKeyPEM(data, tt.password)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s with %q: error = %v, want %q", tt.file, tt.password, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.file, err)
+			continue
+		}
+		if !parseTestKey(t, out).Equal(want) {
+			t.Errorf("%s: decrypted key differs from the original", tt.file)
+		}
+	}
+}
+
+// parseTestKey parses the PKCS#8 ECDSA key in a PEM block.
+func parseTestKey(t *testing.T, data []byte) *ecdsa.PrivateKey {
+	t.Helper()
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		t.Fatal("no PEM block")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key.(*ecdsa.PrivateKey)
+}
+
+func TestCertificateLoaderEncryptedPKCS8(t *testing.T) {
+	loader, err := NewCertificateLoader("testdata/cert.pem", "testdata/key-aes256.pem", testKeyPassword)
+	if err != nil {
+		t.Fatalf("NewCertificateLoader: %v", err)
+	}
+	cert, err := loader.Certificate()
+	if err != nil || cert.PrivateKey == nil {
+		t.Fatalf("Certificate: %v", err)
+	}
+}
+
+func TestCertificateLoaderPKCS12(t *testing.T) {
+	cert, err := GenerateCertificate([]string{"client"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := pkcs12.Modern.Encode(cert.PrivateKey, cert.Leaf, nil, testKeyPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p12 := filepath.Join(t.TempDir(), "client.p12")
+	if err := os.WriteFile(p12, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewCertificateLoader(p12, "", testKeyPassword); err != nil {
+		t.Errorf("PKCS#12 without a key file: %v", err)
+	}
+	if _, err := NewCertificateLoader(p12, "testdata/key.pem", testKeyPassword); err == nil {
+		t.Error("PKCS#12 with a separate key file was accepted")
+	}
+}
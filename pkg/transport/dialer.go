@@ -3,65 +3,411 @@ package transport
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
+// BindOptions selects the local end of outgoing connections.
+type BindOptions struct {
+	Addr      string // source IP address
+	Port      int    // source port
+	Interface string // network interface (SO_BINDTODEVICE, Linux only)
+}
+
+// IsZero reports whether no local binding is requested.
+func (b BindOptions) IsZero() bool {
+	return b.Addr == "" && b.Port == 0 && b.Interface == ""
+}
+
+// String describes the binding for verbose output.
+func (b BindOptions) String() string {
+	var parts []string
+	if b.Addr != "" || b.Port != 0 {
+		parts = append(parts, net.JoinHostPort(b.Addr, fmt.Sprint(b.Port)))
+	}
+	if b.Interface != "" {
+		parts = append(parts, "interface "+b.Interface)
+	}
+	return strings.Join(parts, " on ")
+}
+
+// Dialer returns a net.Dialer for network that binds the local address,
+// port and interface.
+func (b BindOptions) Dialer(network string, timeout time.Duration) (*net.Dialer, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	if b.IsZero() {
+		return dialer, nil
+	}
+
+	var ip net.IP
+	if b.Addr != "" {
+		if ip = net.ParseIP(b.Addr); ip == nil {
+			return nil, fmt.Errorf("invalid source address: %s", b.Addr)
+		}
+	}
+	if b.Port < 0 || b.Port > 65535 {
+		return nil, fmt.Errorf("source port out of range: %d", b.Port)
+	}
+
+	if ip != nil || b.Port != 0 {
+		switch network {
+		case "tcp", "tcp4", "tcp6":
+			dialer.LocalAddr = &net.TCPAddr{IP: ip, Port: b.Port}
+		case "udp", "udp4", "udp6":
+			dialer.LocalAddr = &net.UDPAddr{IP: ip, Port: b.Port}
+		default:
+			return nil, fmt.Errorf("cannot bind a source address for network %s", network)
+		}
+	}
+
+	iface, reuse := b.Interface, b.Port != 0
+	if iface != "" || reuse {
+		dialer.Control = func(_, _ string, c syscall.RawConn) error {
+			return controlBind(c, iface, reuse)
+		}
+	}
+	return dialer, nil
+}
+
+// Address families for DialOptions.Family.
+const (
+	FamilyAny  = ""
+	FamilyIPv4 = "4"
+	FamilyIPv6 = "6"
+)
+
+// DialOptions controls how outgoing connections are established.
+type DialOptions struct {
+	Bind   BindOptions
+	Family string // FamilyAny, FamilyIPv4 or FamilyIPv6
+
+	// FallbackDelay is the Happy Eyeballs delay before racing the other
+	// address family. Zero uses the Go default (300ms); negative disables
+	// racing.
+	FallbackDelay time.Duration
+
+	// AllAddresses tries every resolved address in turn, each with the
+	// full timeout, instead of racing them.
+	AllAddresses bool
+
+	// Resolver, if set, is used instead of the system resolver.
+	Resolver *Resolver
+
+	// Overrides redirects connections to other addresses (--resolve,
+	// --connect-to).
+	Overrides *AddressOverrides
+
+	// Socket tunes every outgoing socket.
+	Socket SocketOptions
+
+	// MultipathTCP dials with MPTCP, falling back to TCP if the server
+	// or the kernel does not support it.
+	MultipathTCP bool
+
+	// Verbose reports the address each connection was established to.
+	Verbose bool
+}
+
+// Network restricts network ("tcp" or "udp") to the configured family.
+func (o DialOptions) Network(network string) string {
+	switch network {
+	case "tcp", "udp":
+		return network + o.Family
+	default:
+		return network
+	}
+}
+
+// Dial connects to address, applying the overrides, family, Happy Eyeballs
+// and local binding settings.
+func (o DialOptions) Dial(network, address string, timeout time.Duration) (net.Conn, error) {
+	network = o.Network(network)
+
+	dialer, err := o.Bind.Dialer(network, timeout)
+	if err != nil {
+		return nil, err
+	}
+	dialer.FallbackDelay = o.FallbackDelay
+	if o.MultipathTCP {
+		dialer.SetMultipathTCP(true)
+	}
+	if o.Socket.needsControl() {
+		bindControl := dialer.Control
+		dialer.Control = func(network, address string, c syscall.RawConn) error {
+			if bindControl != nil {
+				if err := bindControl(network, address, c); err != nil {
+					return err
+				}
+			}
+			return o.Socket.control(network, c)
+		}
+		if o.Socket.keepAlive() {
+			// Keep Go from replacing the keepalive settings after connect.
+			dialer.KeepAlive = -1
+		}
+	}
+	if o.Resolver != nil {
+		dialer.Resolver = o.Resolver.NetResolver()
+	}
+
+	targets := o.Overrides.Rewrite(address)
+	if o.Verbose && (len(targets) > 1 || targets[0] != address) {
+		fmt.Fprintf(os.Stderr, "Redirecting %s to %s\n", address, strings.Join(targets, ", "))
+	}
+
+	var conn net.Conn
+	switch {
+	case len(targets) > 1:
+		conn, err = o.dialInTurn(dialer, network, address, targets, timeout)
+	case o.AllAddresses && !isUnix(network):
+		conn, err = o.dialEach(dialer, network, targets[0], timeout)
+	default:
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		conn, err = dialer.DialContext(ctx, network, targets[0])
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Go enables TCP_NODELAY once connected, so it can only be turned
+	// off afterwards.
+	if tcpConn, ok := conn.(*net.TCPConn); ok && o.Socket.Nagle {
+		if err := tcpConn.SetNoDelay(false); err != nil {
+			_ = conn.Close()
+			return nil, &sockoptError{name: "TCP_NODELAY", err: err}
+		}
+	}
+
+	if o.Verbose && isUnix(network) {
+		fmt.Fprintf(os.Stderr, "Connected to %s\n", address)
+	} else if o.Verbose {
+		fmt.Fprintf(os.Stderr, "Connected to %s at %s from %s\n", address, conn.RemoteAddr(), conn.LocalAddr())
+		if tcpConn, ok := conn.(*net.TCPConn); ok && (o.MultipathTCP || o.Socket.FastOpen) {
+			conn = &featureReportConn{TCPConn: tcpConn, address: address, multipath: o.MultipathTCP, fastOpen: o.Socket.FastOpen}
+		}
+	}
+	return conn, nil
+}
+
+// isUnix reports whether network is a Unix domain socket network.
+func isUnix(network string) bool {
+	return strings.HasPrefix(network, "unix")
+}
+
+// featureReportConn reports whether MPTCP and TCP Fast Open were used once
+// the first data arrives, or at the latest when it is closed: with Fast
+// Open, the handshake only completes after the first write.
+type featureReportConn struct {
+	*net.TCPConn
+	address   string
+	multipath bool
+	fastOpen  bool
+	once      sync.Once
+}
+
+func (c *featureReportConn) Read(b []byte) (int, error) {
+	n, err := c.TCPConn.Read(b)
+	c.once.Do(c.report)
+	return n, err
+}
+
+func (c *featureReportConn) Close() error {
+	c.once.Do(c.report)
+	return c.TCPConn.Close()
+}
+
+func (c *featureReportConn) report() {
+	fmt.Fprintf(os.Stderr, "Connection to %s: %s\n", c.address, DescribeTCPFeatures(c.TCPConn, c.multipath, c.fastOpen))
+}
+
+// dialEach resolves address and tries each IP in turn.
+func (o DialOptions) dialEach(dialer *net.Dialer, network, address string, timeout time.Duration) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	resolver := net.DefaultResolver
+	if dialer.Resolver != nil {
+		resolver = dialer.Resolver
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	ips, err := resolver.LookupNetIP(ctx, "ip"+o.Family, host)
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+
+	targets := make([]string, len(ips))
+	for i, ip := range ips {
+		targets[i] = net.JoinHostPort(ip.Unmap().String(), port)
+	}
+	return o.dialInTurn(dialer, network, host, targets, timeout)
+}
+
+// dialInTurn tries each target address of name in turn, each with the
+// full timeout.
+func (o DialOptions) dialInTurn(dialer *net.Dialer, network, name string, targets []string, timeout time.Duration) (net.Conn, error) {
+	var errs []error
+	for _, target := range targets {
+		if o.Verbose {
+			fmt.Fprintf(os.Stderr, "Trying %s...\n", target)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		conn, err := dialer.DialContext(ctx, network, target)
+		cancel()
+		if err == nil {
+			return conn, nil
+		}
+		if o.Verbose {
+			fmt.Fprintf(os.Stderr, "  %v\n", err)
+		}
+		errs = append(errs, err)
+	}
+	return nil, fmt.Errorf("no address of %s accepted the connection (%d tried): %w", name, len(targets), errors.Join(errs...))
+}
+
 // DirectDialer implements a direct TCP connection without any proxy.
 type DirectDialer struct {
 	timeout time.Duration
+	opts    DialOptions
 }
 
-// NewDirectDialer creates a new direct dialer with the specified timeout.
-func NewDirectDialer(timeout time.Duration) *DirectDialer {
+// NewDirectDialer creates a new direct dialer with the specified timeout
+// and dial options.
+func NewDirectDialer(timeout time.Duration, opts DialOptions) *DirectDialer {
 	if timeout == 0 {
 		timeout = 30 * time.Second
 	}
-	return &DirectDialer{timeout: timeout}
+	return &DirectDialer{timeout: timeout, opts: opts}
 }
 
 // Dial connects directly to the target address.
 func (d *DirectDialer) Dial(network, address string) (net.Conn, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
-	defer cancel()
-
-	return (&net.Dialer{}).DialContext(ctx, network, address)
+	return d.opts.Dial(network, address, d.timeout)
 }
 
-// TimeoutDialer wraps a connection with read/write timeouts.
+// TimeoutDialer wraps a connection with read, write and idle timeouts.
+// A timeout that fires is returned as a *TimeoutError.
 type TimeoutDialer struct {
 	net.Conn
 	readTimeout  time.Duration
 	writeTimeout time.Duration
+	idleTimeout  time.Duration
+
+	lastActive atomic.Int64 // unix nanoseconds of the last read or write
 }
 
-// NewTimeoutDialer wraps a connection with timeout support.
+// NewTimeoutDialer wraps a connection with timeout support. Each read
+// fails after readTimeout without data and each write after writeTimeout
+// without progress; zero disables either.
 func NewTimeoutDialer(conn net.Conn, readTimeout, writeTimeout time.Duration) *TimeoutDialer {
-	if readTimeout == 0 {
-		readTimeout = 30 * time.Second
-	}
-	if writeTimeout == 0 {
-		writeTimeout = 30 * time.Second
-	}
-	return &TimeoutDialer{
+	t := &TimeoutDialer{
 		Conn:         conn,
 		readTimeout:  readTimeout,
 		writeTimeout: writeTimeout,
 	}
+	t.touch()
+	return t
+}
+
+// SetIdleTimeout makes reads fail once no data has been read or written
+// for d. Zero disables the idle timeout.
+func (t *TimeoutDialer) SetIdleTimeout(d time.Duration) {
+	t.idleTimeout = d
 }
 
 // Read implements io.Reader with timeout.
 func (t *TimeoutDialer) Read(p []byte) (int, error) {
-	if err := t.SetReadDeadline(time.Now().Add(t.readTimeout)); err != nil {
-		return 0, err
+	start := time.Now()
+	for {
+		var deadline time.Time
+		if t.readTimeout > 0 {
+			deadline = start.Add(t.readTimeout)
+		}
+		if t.idleTimeout > 0 {
+			idle := time.Unix(0, t.lastActive.Load()).Add(t.idleTimeout)
+			if deadline.IsZero() || idle.Before(deadline) {
+				deadline = idle
+			}
+		}
+		if err := t.SetReadDeadline(deadline); err != nil {
+			return 0, err
+		}
+
+		n, err := t.Conn.Read(p)
+		if n > 0 {
+			t.touch()
+		}
+		if err == nil || !errors.Is(err, os.ErrDeadlineExceeded) {
+			return n, err
+		}
+
+		switch {
+		case t.idleTimeout > 0 && time.Since(time.Unix(0, t.lastActive.Load())) >= t.idleTimeout:
+			return n, &TimeoutError{Kind: "idle", Duration: t.idleTimeout}
+		case t.readTimeout > 0 && time.Since(start) >= t.readTimeout:
+			return n, &TimeoutError{Kind: "read", Duration: t.readTimeout}
+		}
+		// Data was written since the idle deadline was set.
 	}
-	return t.Conn.Read(p)
 }
 
 // Write implements io.Writer with timeout.
 func (t *TimeoutDialer) Write(p []byte) (int, error) {
-	if err := t.SetWriteDeadline(time.Now().Add(t.writeTimeout)); err != nil {
+	var deadline time.Time
+	if t.writeTimeout > 0 {
+		deadline = time.Now().Add(t.writeTimeout)
+	}
+	if err := t.SetWriteDeadline(deadline); err != nil {
 		return 0, err
 	}
-	return t.Conn.Write(p)
+
+	n, err := t.Conn.Write(p)
+	if n > 0 {
+		t.touch()
+	}
+	if err != nil && t.writeTimeout > 0 && errors.Is(err, os.ErrDeadlineExceeded) {
+		return n, &TimeoutError{Kind: "write", Duration: t.writeTimeout}
+	}
+	return n, err
 }
+
+func (t *TimeoutDialer) touch() {
+	t.lastActive.Store(time.Now().UnixNano())
+}
+
+// TimeoutError reports that an idle, read or write timeout fired.
+type TimeoutError struct {
+	Kind     string // "idle", "read" or "write"
+	Duration time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("%s timeout of %v expired", e.Kind, e.Duration)
+}
+
+// Timeout reports true, as for net.Error.
+func (e *TimeoutError) Timeout() bool { return true }
+
+// bindError reports a failure to bind a socket to an interface.
+type bindError struct {
+	iface string
+	err   error
+}
+
+func (e *bindError) Error() string {
+	return "failed to bind to interface " + e.iface + ": " + e.err.Error()
+}
+
+func (e *bindError) Unwrap() error { return e.err }
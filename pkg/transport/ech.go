@@ -0,0 +1,88 @@
+package transport
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// LoadECHConfigList resolves an ECHConfigList from source, which is either
+// a file (raw or base64) or a base64 string.
+func LoadECHConfigList(source string) ([]byte, error) {
+	if data, err := os.ReadFile(source); err == nil {
+		if decoded, err := decodeECHBase64(string(data)); err == nil {
+			return decoded, nil
+		}
+		return data, nil
+	}
+
+	decoded, err := decodeECHBase64(source)
+	if err != nil {
+		return nil, fmt.Errorf("ECH config is neither a readable file nor valid base64: %s", source)
+	}
+	return decoded, nil
+}
+
+func decodeECHBase64(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, errors.New("empty ECH config")
+	}
+	if decoded, err := base64.StdEncoding.DecodeString(s); err == nil {
+		return decoded, nil
+	}
+	return base64.RawStdEncoding.DecodeString(s)
+}
+
+// LookupECHConfigList fetches the ECHConfigList from the DNS HTTPS record
+// of host (RFC 9460) using resolver. For ports other than 443 the record is
+// looked up at _port._https.host.
+func LookupECHConfigList(host, port string, resolver *Resolver, timeout time.Duration) ([]byte, error) {
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+
+	qname := host
+	if port != "" && port != "443" {
+		qname = fmt.Sprintf("_%s._https.%s", port, host)
+	}
+	name, err := dnsmessage.NewName(strings.TrimSuffix(qname, ".") + ".")
+	if err != nil {
+		return nil, fmt.Errorf("invalid name for HTTPS record lookup: %w", err)
+	}
+
+	msg := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: uint16(rand.IntN(1 << 16)), RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: name, Type: dnsmessage.TypeHTTPS, Class: dnsmessage.ClassINET}},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	resp, err := resolver.Exchange(ctx, msg)
+	if err != nil {
+		return nil, fmt.Errorf("HTTPS record lookup for %s failed: %w", qname, err)
+	}
+	if resp.Header.RCode != dnsmessage.RCodeSuccess {
+		return nil, fmt.Errorf("HTTPS record lookup for %s failed: %v", qname, resp.Header.RCode)
+	}
+
+	for _, answer := range resp.Answers {
+		rr, ok := answer.Body.(*dnsmessage.HTTPSResource)
+		if !ok {
+			continue
+		}
+		if ech, ok := rr.GetParam(dnsmessage.SVCParamECH); ok {
+			return ech, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no ECH config in HTTPS record for %s", qname)
+}
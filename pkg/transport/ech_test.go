@@ -0,0 +1,148 @@
+package transport
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+const (
+	echPublicName = "public.example"
+	echInnerName  = "secret.example"
+)
+
+// echKey generates an X25519 ECH key and its marshalled ECHConfig with
+// the given config ID.
+func echKey(t *testing.T, id byte) tls.EncryptedClientHelloKey {
+	t.Helper()
+
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := key.PublicKey().Bytes()
+
+	var contents []byte
+	contents = append(contents, id)
+	contents = binary.BigEndian.AppendUint16(contents, 0x0020) // DHKEM(X25519, HKDF-SHA256)
+	contents = binary.BigEndian.AppendUint16(contents, uint16(len(pub)))
+	contents = append(contents, pub...)
+	contents = binary.BigEndian.AppendUint16(contents, 4)
+	contents = binary.BigEndian.AppendUint16(contents, 0x0001) // HKDF-SHA256
+	contents = binary.BigEndian.AppendUint16(contents, 0x0001) // AES-128-GCM
+	contents = append(contents, 0)                             // maximum name length
+	contents = append(contents, byte(len(echPublicName)))
+	contents = append(contents, echPublicName...)
+	contents = binary.BigEndian.AppendUint16(contents, 0) // no extensions
+
+	config := binary.BigEndian.AppendUint16(nil, 0xfe0d)
+	config = binary.BigEndian.AppendUint16(config, uint16(len(contents)))
+	config = append(config, contents...)
+
+	return tls.EncryptedClientHelloKey{Config: config, PrivateKey: key.Bytes()}
+}
+
+// echConfigList wraps ECHConfigs into an ECHConfigList.
+func echConfigList(keys ...tls.EncryptedClientHelloKey) []byte {
+	var configs []byte
+	for _, key := range keys {
+		configs = append(configs, key.Config...)
+	}
+	return append(binary.BigEndian.AppendUint16(nil, uint16(len(configs))), configs...)
+}
+
+// echServer starts a TLS 1.3 server with the given ECH keys and a
+// certificate for both the public and the inner name. It returns the
+// server address and a CA file trusting its certificate.
+func echServer(t *testing.T, keys []tls.EncryptedClientHelloKey) (string, string) {
+	t.Helper()
+
+	cert, err := GenerateCertificate([]string{echPublicName, echInnerName}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := serveTLS(t, &tls.Config{
+		Certificates:             []tls.Certificate{*cert},
+		MinVersion:               tls.VersionTLS13,
+		EncryptedClientHelloKeys: keys,
+	}, func(conn *tls.Conn) {
+		_, _ = conn.Write([]byte("ok"))
+	})
+	return address, writeCAFile(t, cert.Leaf)
+}
+
+// dialECH connects to the inner name with ECH and retries enabled.
+func dialECH(t *testing.T, address, caFile string, configList []byte) (net.Conn, error) {
+	t.Helper()
+
+	wrapper, err := NewTLSWrapper(TLSOptions{
+		ServerName:    echInnerName,
+		CAFile:        caFile,
+		ECHConfigList: configList,
+		ECHRetry:      true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return wrapper.DialTLS(func() (net.Conn, error) {
+		return net.Dial("tcp", address)
+	}, 5*time.Second)
+}
+
+func TestECHAccepted(t *testing.T) {
+	key := echKey(t, 1)
+	address, caFile := echServer(t, []tls.EncryptedClientHelloKey{key})
+
+	conn, err := dialECH(t, address, caFile, echConfigList(key))
+	if err != nil {
+		t.Fatalf("DialTLS: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	state := conn.(*tls.Conn).ConnectionState()
+	if !state.ECHAccepted {
+		t.Error("ECH was not accepted")
+	}
+	if state.ServerName != echInnerName {
+		t.Errorf("server name = %q, want %q", state.ServerName, echInnerName)
+	}
+}
+
+func TestECHRejectedWithRetryConfigs(t *testing.T) {
+	current := echKey(t, 1)
+	current.SendAsRetry = true
+	stale := echKey(t, 2)
+	address, caFile := echServer(t, []tls.EncryptedClientHelloKey{current})
+
+	conn, err := dialECH(t, address, caFile, echConfigList(stale))
+	if err != nil {
+		t.Fatalf("DialTLS with retry configs: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	if !conn.(*tls.Conn).ConnectionState().ECHAccepted {
+		t.Error("ECH was not accepted after retrying with the server's configs")
+	}
+}
+
+func TestECHRejectedWithoutRetryConfigs(t *testing.T) {
+	address, caFile := echServer(t, nil)
+
+	conn, err := dialECH(t, address, caFile, echConfigList(echKey(t, 1)))
+	if err == nil {
+		_ = conn.Close()
+		t.Fatal("DialTLS succeeded without ECH; want a hard failure")
+	}
+	var echErr *tls.ECHRejectionError
+	if !errors.As(err, &echErr) {
+		t.Fatalf("error = %v, want an ECH rejection", err)
+	}
+	if len(echErr.RetryConfigList) != 0 {
+		t.Errorf("got %d bytes of retry configs, want none", len(echErr.RetryConfigList))
+	}
+}
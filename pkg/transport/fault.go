@@ -0,0 +1,543 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrFaultReset is returned once a FaultConn has reset the connection.
+var ErrFaultReset = errors.New("connection reset by fault injection")
+
+// FaultOptions describes the network faults a FaultConn injects. Each
+// applies to both directions independently.
+type FaultOptions struct {
+	Latency    time.Duration // delay before each chunk is delivered
+	Jitter     time.Duration // random extra delay, up to this
+	Bandwidth  int64         // bytes per second
+	StallProb  float64       // probability of a stall before each chunk
+	StallTime  time.Duration // length of a stall
+	Corrupt    float64       // probability of flipping a bit in each byte
+	Fragment   int           // split chunks into random sizes up to this
+	ResetBytes int64         // reset after this many bytes, both directions together
+	ResetAfter time.Duration // reset after this long
+	Seed       uint64        // random seed; zero picks one
+}
+
+// IsZero reports whether no fault is configured.
+func (f FaultOptions) IsZero() bool {
+	return f.Latency == 0 && f.Jitter == 0 && f.Bandwidth == 0 && f.StallProb == 0 &&
+		f.Corrupt == 0 && f.Fragment == 0 && f.ResetBytes == 0 && f.ResetAfter == 0
+}
+
+// String describes the faults in the ParseFaultOptions syntax.
+func (f FaultOptions) String() string {
+	var parts []string
+	add := func(key string, value any) {
+		parts = append(parts, fmt.Sprintf("%s=%v", key, value))
+	}
+	if f.Latency > 0 {
+		add("latency", f.Latency)
+	}
+	if f.Jitter > 0 {
+		add("jitter", f.Jitter)
+	}
+	if f.Bandwidth > 0 {
+		add("bandwidth", FormatSize(float64(f.Bandwidth)))
+	}
+	if f.StallProb > 0 {
+		add("stall", fmt.Sprintf("%g:%v", f.StallProb, f.StallTime))
+	}
+	if f.Corrupt > 0 {
+		add("corrupt", f.Corrupt)
+	}
+	if f.Fragment > 0 {
+		add("fragment", f.Fragment)
+	}
+	if f.ResetBytes > 0 {
+		add("reset-bytes", f.ResetBytes)
+	}
+	if f.ResetAfter > 0 {
+		add("reset-after", f.ResetAfter)
+	}
+	add("seed", f.Seed)
+	return strings.Join(parts, ",")
+}
+
+// ParseFaultOptions applies a fault spec to f. The spec is a list of
+// key=value settings separated by commas or newlines; blank lines and
+// lines starting with "#" are ignored, so it can be read from a file:
+//
+//	latency=100ms      delay each chunk
+//	jitter=20ms        random extra delay, up to this
+//	bandwidth=64K      bytes per second
+//	stall=0.01:2s      stall with this probability per chunk, this long
+//	corrupt=0.0001     flip a bit with this probability per byte
+//	fragment=16        split chunks into random sizes up to this
+//	reset-bytes=1M     reset after this many bytes
+//	reset-after=30s    reset after this long
+//	seed=42            random seed, for reproducible runs
+func ParseFaultOptions(spec string, f *FaultOptions) error {
+	for _, line := range strings.Split(spec, "\n") {
+		if line = strings.TrimSpace(line); line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		for _, setting := range strings.Split(line, ",") {
+			if setting = strings.TrimSpace(setting); setting == "" {
+				continue
+			}
+			key, value, ok := strings.Cut(setting, "=")
+			if !ok {
+				return fmt.Errorf("invalid fault setting %q: expected key=value", setting)
+			}
+			if err := f.set(strings.TrimSpace(key), strings.TrimSpace(value)); err != nil {
+				return fmt.Errorf("invalid fault setting %q: %w", setting, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (f *FaultOptions) set(key, value string) error {
+	var err error
+	switch key {
+	case "latency":
+		f.Latency, err = parseFaultDuration(value)
+	case "jitter":
+		f.Jitter, err = parseFaultDuration(value)
+	case "bandwidth":
+		f.Bandwidth, err = ParseSize(value)
+	case "stall":
+		prob, duration, ok := strings.Cut(value, ":")
+		if !ok {
+			return errors.New("expected probability:duration")
+		}
+		if f.StallProb, err = parseProbability(prob); err != nil {
+			return err
+		}
+		f.StallTime, err = parseFaultDuration(duration)
+	case "corrupt":
+		f.Corrupt, err = parseProbability(value)
+	case "fragment":
+		f.Fragment, err = strconv.Atoi(value)
+		if err == nil && f.Fragment < 1 {
+			err = errors.New("must be at least 1")
+		}
+	case "reset-bytes":
+		f.ResetBytes, err = ParseSize(value)
+	case "reset-after":
+		f.ResetAfter, err = parseFaultDuration(value)
+	case "seed":
+		f.Seed, err = strconv.ParseUint(value, 10, 64)
+	default:
+		return fmt.Errorf("unknown fault %q", key)
+	}
+	return err
+}
+
+func parseFaultDuration(value string) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, errors.New("must not be negative")
+	}
+	return d, nil
+}
+
+func parseProbability(value string) (float64, error) {
+	p, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, err
+	}
+	if p < 0 || p > 1 {
+		return 0, errors.New("probability must be between 0 and 1")
+	}
+	return p, nil
+}
+
+// Wrap injects the faults into conn. It returns conn itself if no fault
+// is configured.
+func (f FaultOptions) Wrap(conn net.Conn, verbose bool) net.Conn {
+	if f.IsZero() {
+		return conn
+	}
+	return NewFaultConn(conn, f, verbose)
+}
+
+// faultChunk is a piece of data, or the final error, due for delivery.
+type faultChunk struct {
+	data  []byte
+	due   time.Time
+	err   error
+	reset bool // reset the connection once delivered
+}
+
+// faultLink models one direction of the connection: chunks are
+// fragmented and corrupted, then delivered after the serialization delay
+// for the bandwidth, any stall and the latency, in order. Fragmentation
+// and corruption depend only on the seed and the stream offset, so runs
+// with the same seed and data are reproducible.
+type faultLink struct {
+	opts    FaultOptions
+	name    string
+	verbose bool
+
+	fragmentRand *rand.Rand
+	corruptRand  *rand.Rand
+	timingRand   *rand.Rand
+
+	offset    int64 // bytes scheduled so far
+	nextCut   int64 // stream offset of the next fragment boundary
+	busyUntil time.Time
+	lastDue   time.Time
+}
+
+func newFaultLink(opts FaultOptions, name string, stream uint64, verbose bool) *faultLink {
+	return &faultLink{
+		opts:         opts,
+		name:         name,
+		verbose:      verbose,
+		fragmentRand: rand.New(rand.NewPCG(opts.Seed, stream<<8|1)),
+		corruptRand:  rand.New(rand.NewPCG(opts.Seed, stream<<8|2)),
+		timingRand:   rand.New(rand.NewPCG(opts.Seed, stream<<8|3)),
+	}
+}
+
+func (l *faultLink) schedule(data []byte) []faultChunk {
+	var chunks []faultChunk
+	for len(data) > 0 {
+		size := len(data)
+		if l.opts.Fragment > 0 {
+			if l.nextCut <= l.offset {
+				l.nextCut = l.offset + 1 + int64(l.fragmentRand.IntN(l.opts.Fragment))
+			}
+			size = int(min(int64(size), l.nextCut-l.offset))
+		}
+		piece := append([]byte(nil), data[:size]...)
+		data = data[size:]
+		l.offset += int64(size)
+
+		if l.opts.Corrupt > 0 {
+			corrupted := 0
+			for i := range piece {
+				if l.corruptRand.Float64() < l.opts.Corrupt {
+					piece[i] ^= 1 << l.corruptRand.IntN(8)
+					corrupted++
+				}
+			}
+			if corrupted > 0 && l.verbose {
+				fmt.Fprintf(os.Stderr, "Fault: corrupted %d bytes of %s\n", corrupted, l.name)
+			}
+		}
+
+		start := time.Now()
+		if l.busyUntil.After(start) {
+			start = l.busyUntil
+		}
+		if l.opts.StallProb > 0 && l.timingRand.Float64() < l.opts.StallProb {
+			if l.verbose {
+				fmt.Fprintf(os.Stderr, "Fault: stalling %s for %v\n", l.name, l.opts.StallTime)
+			}
+			start = start.Add(l.opts.StallTime)
+		}
+		l.busyUntil = start
+		if l.opts.Bandwidth > 0 {
+			l.busyUntil = start.Add(time.Duration(float64(size) / float64(l.opts.Bandwidth) * float64(time.Second)))
+		}
+
+		due := l.busyUntil.Add(l.opts.Latency)
+		if l.opts.Jitter > 0 {
+			due = due.Add(time.Duration(l.timingRand.Int64N(int64(l.opts.Jitter) + 1)))
+		}
+		if due.Before(l.lastDue) {
+			due = l.lastDue
+		}
+		l.lastDue = due
+
+		chunks = append(chunks, faultChunk{data: piece, due: due})
+	}
+	return chunks
+}
+
+// FaultConn injects latency, jitter, bandwidth caps, stalls, corruption,
+// fragmentation and resets into a connection. Received data is read
+// ahead and written data is queued, so latency does not reduce the
+// throughput.
+type FaultConn struct {
+	net.Conn
+	opts    FaultOptions
+	verbose bool
+
+	incoming *faultLink
+	outgoing *faultLink
+	in       chan faultChunk
+	out      chan faultChunk
+	pending  faultChunk
+	writeMu  sync.Mutex
+
+	readDeadline atomic.Pointer[time.Time]
+	transferred  atomic.Int64
+	writeErr     atomic.Pointer[error]
+
+	closed    chan struct{}
+	closeOnce sync.Once
+	closeErr  error
+	resetOnce sync.Once
+}
+
+// NewFaultConn wraps conn with the given faults.
+func NewFaultConn(conn net.Conn, opts FaultOptions, verbose bool) *FaultConn {
+	if opts.Seed == 0 {
+		opts.Seed = rand.Uint64()
+	}
+	if verbose {
+		fmt.Fprintf(os.Stderr, "Injecting faults: %s\n", opts)
+	}
+
+	c := &FaultConn{
+		Conn:     conn,
+		opts:     opts,
+		verbose:  verbose,
+		incoming: newFaultLink(opts, "incoming data", 1, verbose),
+		outgoing: newFaultLink(opts, "outgoing data", 2, verbose),
+		in:       make(chan faultChunk, 64),
+		out:      make(chan faultChunk, 64),
+		closed:   make(chan struct{}),
+	}
+
+	go c.readLoop()
+	go c.writeLoop()
+	if opts.ResetAfter > 0 {
+		timer := time.AfterFunc(opts.ResetAfter, func() {
+			c.reset(fmt.Sprintf("after %v", opts.ResetAfter))
+		})
+		go func() {
+			<-c.closed
+			timer.Stop()
+		}()
+	}
+	return c
+}
+
+// readLoop reads ahead from the connection and schedules the data.
+func (c *FaultConn) readLoop() {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := c.Conn.Read(buf)
+		chunks := c.incoming.schedule(buf[:n])
+		if err != nil {
+			chunks = append(chunks, faultChunk{err: err, due: c.incoming.lastDue})
+		}
+		for _, chunk := range chunks {
+			select {
+			case c.in <- chunk:
+			case <-c.closed:
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// writeLoop writes queued chunks once they are due.
+func (c *FaultConn) writeLoop() {
+	for {
+		var chunk faultChunk
+		select {
+		case chunk = <-c.out:
+		case <-c.closed:
+			return
+		}
+		if !c.sleepUntil(chunk.due) {
+			return
+		}
+		if chunk.reset {
+			c.reset(fmt.Sprintf("after %d bytes", c.opts.ResetBytes))
+			return
+		}
+		if _, err := c.Conn.Write(chunk.data); err != nil {
+			c.writeErr.Store(&err)
+			return
+		}
+	}
+}
+
+// sleepUntil waits for t, returning false if the connection is closed
+// first.
+func (c *FaultConn) sleepUntil(t time.Time) bool {
+	d := time.Until(t)
+	if d <= 0 {
+		return true
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-c.closed:
+		return false
+	}
+}
+
+// budget returns how many of n bytes may still be transferred before the
+// connection is reset.
+func (c *FaultConn) budget(n int) int {
+	if c.opts.ResetBytes == 0 {
+		return n
+	}
+	return int(max(0, min(int64(n), c.opts.ResetBytes-c.transferred.Load())))
+}
+
+// Read returns received data once it is due.
+func (c *FaultConn) Read(p []byte) (int, error) {
+	var deadline <-chan time.Time
+	if t := c.readDeadline.Load(); t != nil && !t.IsZero() {
+		timer := time.NewTimer(time.Until(*t))
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	if c.pending.data == nil && c.pending.err == nil {
+		select {
+		case c.pending = <-c.in:
+		case <-c.closed:
+			return 0, c.closeErr
+		case <-deadline:
+			return 0, os.ErrDeadlineExceeded
+		}
+	}
+
+	if wait := time.Until(c.pending.due); wait > 0 {
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-c.closed:
+			timer.Stop()
+			return 0, c.closeErr
+		case <-deadline:
+			timer.Stop()
+			return 0, os.ErrDeadlineExceeded
+		}
+	}
+
+	if c.pending.data == nil {
+		return 0, c.pending.err
+	}
+
+	allowed := c.budget(len(c.pending.data))
+	if allowed == 0 {
+		// A reset is queued behind the data already written.
+		select {
+		case <-c.closed:
+			return 0, c.closeErr
+		case <-deadline:
+			return 0, os.ErrDeadlineExceeded
+		}
+	}
+	n := copy(p, c.pending.data[:allowed])
+	if c.pending.data = c.pending.data[n:]; len(c.pending.data) == 0 {
+		c.pending.data = nil
+	}
+	if c.transferred.Add(int64(n)); c.opts.ResetBytes > 0 && c.budget(1) == 0 {
+		c.reset(fmt.Sprintf("after %d bytes", c.opts.ResetBytes))
+	}
+	return n, nil
+}
+
+// Write queues p for delivery. It fails with ErrFaultReset once the
+// connection has been reset.
+func (c *FaultConn) Write(p []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if err := c.writeErr.Load(); err != nil {
+		return 0, *err
+	}
+	select {
+	case <-c.closed:
+		return 0, c.closeErr
+	default:
+	}
+
+	allowed := c.budget(len(p))
+	chunks := c.outgoing.schedule(p[:allowed])
+	c.transferred.Add(int64(allowed))
+	if allowed < len(p) || (c.opts.ResetBytes > 0 && c.budget(1) == 0) {
+		chunks = append(chunks, faultChunk{reset: true, due: c.outgoing.lastDue})
+	}
+
+	for _, chunk := range chunks {
+		select {
+		case c.out <- chunk:
+		case <-c.closed:
+			return 0, c.closeErr
+		}
+	}
+	if allowed < len(p) {
+		return allowed, ErrFaultReset
+	}
+	return len(p), nil
+}
+
+// SetDeadline sets the read deadline, which FaultConn handles itself, and
+// the write deadline of the underlying connection.
+func (c *FaultConn) SetDeadline(t time.Time) error {
+	c.readDeadline.Store(&t)
+	return c.Conn.SetWriteDeadline(t)
+}
+
+// SetReadDeadline sets the deadline for Read.
+func (c *FaultConn) SetReadDeadline(t time.Time) error {
+	c.readDeadline.Store(&t)
+	return nil
+}
+
+// reset aborts the connection with a TCP RST where possible.
+func (c *FaultConn) reset(reason string) {
+	c.resetOnce.Do(func() {
+		if c.verbose {
+			fmt.Fprintf(os.Stderr, "Fault: resetting the connection %s\n", reason)
+		}
+		if tcpConn, ok := underlyingConn(c.Conn).(*net.TCPConn); ok {
+			_ = tcpConn.SetLinger(0)
+		}
+		c.close(ErrFaultReset)
+	})
+}
+
+// Close closes the connection, dropping data not yet delivered.
+func (c *FaultConn) Close() error {
+	return c.close(net.ErrClosed)
+}
+
+func (c *FaultConn) close(err error) error {
+	var closeErr error
+	c.closeOnce.Do(func() {
+		c.closeErr = err
+		close(c.closed)
+		closeErr = c.Conn.Close()
+	})
+	return closeErr
+}
+
+// underlyingConn unwraps TLS and similar connections.
+func underlyingConn(conn net.Conn) net.Conn {
+	for {
+		wrapper, ok := conn.(interface{ NetConn() net.Conn })
+		if !ok {
+			return conn
+		}
+		conn = wrapper.NetConn()
+	}
+}
@@ -0,0 +1,151 @@
+package transport
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"testing"
+	"time"
+)
+
+// receiveFaulty sends data through a FaultConn with opts and returns the
+// sizes of the reads and the data as received.
+func receiveFaulty(t *testing.T, opts FaultOptions, data []byte) ([]int, []byte) {
+	t.Helper()
+
+	local, remote := net.Pipe()
+	conn := NewFaultConn(local, opts, false)
+	defer func() { _ = conn.Close() }()
+	go func() {
+		_, _ = remote.Write(data)
+		_ = remote.Close()
+	}()
+
+	var sizes []int
+	var received []byte
+	buf := make([]byte, len(data))
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		n, err := conn.Read(buf)
+		if n > 0 {
+			sizes = append(sizes, n)
+			received = append(received, buf[:n]...)
+		}
+		if err == io.EOF {
+			return sizes, received
+		}
+		if err != nil {
+			t.Fatalf("Read: %v", err)
+		}
+	}
+}
+
+func TestFaultConnSeededFragmentation(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789abcdef"), 64)
+	opts := FaultOptions{Fragment: 16, Seed: 42}
+
+	sizes, received := receiveFaulty(t, opts, data)
+	if !bytes.Equal(received, data) {
+		t.Fatal("fragmentation changed the data")
+	}
+	for _, n := range sizes {
+		if n < 1 || n > opts.Fragment {
+			t.Fatalf("read of %d bytes, want 1 to %d", n, opts.Fragment)
+		}
+	}
+
+	again, _ := receiveFaulty(t, opts, data)
+	if !slices.Equal(again, sizes) {
+		t.Error("the same seed produced different fragments")
+	}
+	opts.Seed = 43
+	if other, _ := receiveFaulty(t, opts, data); slices.Equal(other, sizes) {
+		t.Error("another seed produced the same fragments")
+	}
+}
+
+func TestFaultConnSeededCorruption(t *testing.T) {
+	data := make([]byte, 4096)
+	opts := FaultOptions{Corrupt: 0.01, Seed: 42}
+
+	_, received := receiveFaulty(t, opts, data)
+	if len(received) != len(data) {
+		t.Fatalf("received %d bytes, want %d", len(received), len(data))
+	}
+	flipped := 0
+	for _, b := range received {
+		if b != 0 {
+			flipped++
+			if b&(b-1) != 0 {
+				t.Fatalf("byte %08b has more than one bit flipped", b)
+			}
+		}
+	}
+	if flipped == 0 || flipped > len(data)/20 {
+		t.Errorf("%d bytes corrupted, want about %d", flipped, len(data)/100)
+	}
+
+	if _, again := receiveFaulty(t, opts, data); !bytes.Equal(again, received) {
+		t.Error("the same seed corrupted different bytes")
+	}
+}
+
+func TestFaultConnResetBytesHonoursReadDeadline(t *testing.T) {
+	local, remote := net.Pipe()
+	defer func() { _ = remote.Close() }()
+	conn := NewFaultConn(local, FaultOptions{ResetBytes: 4, Seed: 42}, false)
+	defer func() { _ = conn.Close() }()
+
+	// Writing uses up the budget. The reset is queued behind the data,
+	// which stays undelivered while the peer does not read.
+	if n, err := conn.Write([]byte("ping")); n != 4 || err != nil {
+		t.Fatalf("Write = %d, %v", n, err)
+	}
+	go func() { _, _ = remote.Write([]byte("pong")) }()
+
+	_ = conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	start := time.Now()
+	if _, err := conn.Read(make([]byte, 4)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Read = %v, want a deadline error", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Read returned after %v", elapsed)
+	}
+
+	// Once the peer takes the data, the connection is reset.
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(remote, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("peer read %q, %v", buf, err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(buf); !errors.Is(err, ErrFaultReset) {
+		t.Errorf("Read after the reset = %v, want ErrFaultReset", err)
+	}
+	if _, err := conn.Write([]byte("x")); !errors.Is(err, ErrFaultReset) {
+		t.Errorf("Write after the reset = %v, want ErrFaultReset", err)
+	}
+}
+
+func TestParseFaultOptionsRoundTrip(t *testing.T) {
+	spec := "latency=100ms,jitter=20ms,bandwidth=64K,stall=0.01:2s,corrupt=0.0001,fragment=16,reset-bytes=1048576,reset-after=30s,seed=42"
+	var f FaultOptions
+	if err := ParseFaultOptions(spec, &f); err != nil {
+		t.Fatal(err)
+	}
+	var again FaultOptions
+	if err := ParseFaultOptions(f.String(), &again); err != nil {
+		t.Fatalf("parsing %q: %v", f.String(), err)
+	}
+	if again != f {
+		t.Errorf("round trip = %+v, want %+v", again, f)
+	}
+
+	for _, bad := range []string{"latency", "fragment=0", "corrupt=2", "stall=0.5", "unknown=1"} {
+		if err := ParseFaultOptions(bad, &f); err == nil {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+}
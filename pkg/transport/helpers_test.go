@@ -0,0 +1,56 @@
+package transport
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeCAFile writes certs to a PEM file and returns its path.
+func writeCAFile(t *testing.T, certs ...*x509.Certificate) string {
+	t.Helper()
+
+	var data []byte
+	for _, cert := range certs {
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// serveTLS starts a TLS server on a loopback port and returns its address.
+// Every connection is handshaken and then passed to handler, if any.
+func serveTLS(t *testing.T, config *tls.Config, handler func(conn *tls.Conn)) string {
+	t.Helper()
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() { _ = conn.Close() }()
+				tlsConn := conn.(*tls.Conn)
+				if err := tlsConn.Handshake(); err != nil || handler == nil {
+					return
+				}
+				handler(tlsConn)
+			}()
+		}
+	}()
+
+	return ln.Addr().String()
+}
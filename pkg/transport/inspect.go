@@ -0,0 +1,319 @@
+package transport
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// oidSCTList is the X.509v3 extension carrying embedded SCTs (RFC 6962).
+var oidSCTList = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2}
+
+// ConnectionReport describes an established TLS connection and the peer
+// certificate chain.
+type ConnectionReport struct {
+	Address     string `json:"address"`
+	Version     string `json:"version"`
+	CipherSuite string `json:"cipher_suite"`
+	ALPN        string `json:"alpn,omitempty"`
+	ServerName  string `json:"server_name,omitempty"`
+	Resumed     bool   `json:"resumed"`
+	ECHAccepted bool   `json:"ech_accepted"`
+	// ResumptionSupported is set when a reconnect was attempted.
+	ResumptionSupported *bool `json:"resumption_supported,omitempty"`
+	// Verification is "ok", "failed" or "skipped"; the handshake
+	// completes either way so that the chain can be reported.
+	Verification string      `json:"verification"`
+	VerifyError  string      `json:"verify_error,omitempty"`
+	OCSPStaple   *OCSPReport `json:"ocsp_staple,omitempty"`
+	// Revocation is the result of the --ocsp check, if enabled.
+	Revocation   *OCSPReport         `json:"revocation,omitempty"`
+	SCTs         []SCTReport         `json:"scts,omitempty"`
+	Certificates []CertificateReport `json:"certificates"`
+}
+
+// CertificateReport describes a single certificate.
+type CertificateReport struct {
+	Subject            string    `json:"subject"`
+	Issuer             string    `json:"issuer"`
+	SerialNumber       string    `json:"serial_number"`
+	SANs               []string  `json:"sans,omitempty"`
+	NotBefore          time.Time `json:"not_before"`
+	NotAfter           time.Time `json:"not_after"`
+	DaysUntilExpiry    int       `json:"days_until_expiry"`
+	KeyType            string    `json:"key_type"`
+	KeySize            int       `json:"key_size"`
+	SignatureAlgorithm string    `json:"signature_algorithm"`
+	IsCA               bool      `json:"is_ca"`
+	SHA256Fingerprint  string    `json:"sha256_fingerprint"`
+	SPKIPin            string    `json:"spki_pin"`
+}
+
+// OCSPReport describes an OCSP response.
+type OCSPReport struct {
+	Source     string     `json:"source,omitempty"` // "staple" or "responder"
+	Status     string     `json:"status"`
+	ProducedAt time.Time  `json:"produced_at"`
+	ThisUpdate time.Time  `json:"this_update"`
+	NextUpdate time.Time  `json:"next_update,omitzero"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	Error      string     `json:"error,omitempty"`
+}
+
+// SCTReport describes a signed certificate timestamp.
+type SCTReport struct {
+	Source    string    `json:"source"` // "tls" or "certificate"
+	LogID     string    `json:"log_id"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// NewConnectionReport builds a report from a connection state.
+func NewConnectionReport(address string, state tls.ConnectionState) *ConnectionReport {
+	r := &ConnectionReport{
+		Address:     address,
+		Version:     tls.VersionName(state.Version),
+		CipherSuite: tls.CipherSuiteName(state.CipherSuite),
+		ALPN:        state.NegotiatedProtocol,
+		ServerName:  state.ServerName,
+		Resumed:     state.DidResume,
+		ECHAccepted: state.ECHAccepted,
+	}
+
+	for _, cert := range state.PeerCertificates {
+		r.Certificates = append(r.Certificates, newCertificateReport(cert))
+	}
+
+	if len(state.OCSPResponse) > 0 && len(state.PeerCertificates) > 0 {
+		var issuer *x509.Certificate
+		if len(state.PeerCertificates) > 1 {
+			issuer = state.PeerCertificates[1]
+		}
+		r.OCSPStaple = newOCSPReport(state.OCSPResponse, state.PeerCertificates[0], issuer)
+		r.OCSPStaple.Source = "staple"
+	}
+
+	for _, raw := range state.SignedCertificateTimestamps {
+		if sct, err := parseSCT(raw); err == nil {
+			sct.Source = "tls"
+			r.SCTs = append(r.SCTs, sct)
+		}
+	}
+	if len(state.PeerCertificates) > 0 {
+		r.SCTs = append(r.SCTs, embeddedSCTs(state.PeerCertificates[0])...)
+	}
+
+	return r
+}
+
+// SetVerification records the outcome of verifying the peer chain, where
+// skipped means that no verification was requested.
+func (r *ConnectionReport) SetVerification(err error, skipped bool) {
+	switch {
+	case err != nil:
+		r.Verification = "failed"
+		// Only the first line; the chain is reported anyway.
+		r.VerifyError = strings.SplitN(err.Error(), "\n", 2)[0]
+	case skipped:
+		r.Verification = "skipped"
+	default:
+		r.Verification = "ok"
+	}
+}
+
+func newCertificateReport(cert *x509.Certificate) CertificateReport {
+	var sans []string
+	for _, name := range cert.DNSNames {
+		sans = append(sans, "DNS:"+name)
+	}
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, "IP:"+ip.String())
+	}
+	for _, email := range cert.EmailAddresses {
+		sans = append(sans, "email:"+email)
+	}
+	for _, uri := range cert.URIs {
+		sans = append(sans, "URI:"+uri.String())
+	}
+
+	keyType, keySize := publicKeyInfo(cert)
+	fingerprint := sha256.Sum256(cert.Raw)
+
+	return CertificateReport{
+		Subject:            cert.Subject.String(),
+		Issuer:             cert.Issuer.String(),
+		SerialNumber:       fmt.Sprintf("%X", cert.SerialNumber),
+		SANs:               sans,
+		NotBefore:          cert.NotBefore,
+		NotAfter:           cert.NotAfter,
+		DaysUntilExpiry:    int(time.Until(cert.NotAfter).Hours() / 24),
+		KeyType:            keyType,
+		KeySize:            keySize,
+		SignatureAlgorithm: cert.SignatureAlgorithm.String(),
+		IsCA:               cert.IsCA,
+		SHA256Fingerprint:  formatFingerprint(fingerprint[:]),
+		SPKIPin:            FormatPin(SPKIHash(cert)),
+	}
+}
+
+// publicKeyInfo returns the key algorithm and size in bits.
+func publicKeyInfo(cert *x509.Certificate) (string, int) {
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return "RSA", key.N.BitLen()
+	case *ecdsa.PublicKey:
+		return "ECDSA", key.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		return "Ed25519", 256
+	default:
+		return cert.PublicKeyAlgorithm.String(), 0
+	}
+}
+
+// embeddedSCTs extracts the SCT list embedded in a certificate.
+func embeddedSCTs(cert *x509.Certificate) []SCTReport {
+	var scts []SCTReport
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidSCTList) {
+			continue
+		}
+		var list []byte
+		if _, err := asn1.Unmarshal(ext.Value, &list); err != nil || len(list) < 2 {
+			return nil
+		}
+		// SignedCertificateTimestampList: uint16 length, then uint16
+		// length-prefixed serialized SCTs.
+		list = list[2:]
+		for len(list) >= 2 {
+			n := int(binary.BigEndian.Uint16(list))
+			if len(list) < 2+n {
+				break
+			}
+			if sct, err := parseSCT(list[2 : 2+n]); err == nil {
+				sct.Source = "certificate"
+				scts = append(scts, sct)
+			}
+			list = list[2+n:]
+		}
+	}
+	return scts
+}
+
+// parseSCT parses the log ID and timestamp of a serialized v1 SCT.
+func parseSCT(raw []byte) (SCTReport, error) {
+	// version(1) || log_id(32) || timestamp(8) || ...
+	if len(raw) < 41 || raw[0] != 0 {
+		return SCTReport{}, fmt.Errorf("unsupported SCT")
+	}
+	ms := int64(binary.BigEndian.Uint64(raw[33:41]))
+	return SCTReport{
+		LogID:     base64.StdEncoding.EncodeToString(raw[1:33]),
+		Timestamp: time.UnixMilli(ms).UTC(),
+	}, nil
+}
+
+func formatFingerprint(sum []byte) string {
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+// WriteText writes a human-readable report.
+func (r *ConnectionReport) WriteText(w io.Writer) {
+	fmt.Fprintf(w, "Connection to %s\n", r.Address)
+	fmt.Fprintf(w, "  Protocol:     %s\n", r.Version)
+	fmt.Fprintf(w, "  Cipher suite: %s\n", r.CipherSuite)
+	if r.ALPN != "" {
+		fmt.Fprintf(w, "  ALPN:         %s\n", r.ALPN)
+	}
+	resumption := yesNo(r.Resumed)
+	if r.ResumptionSupported != nil {
+		resumption += fmt.Sprintf(" (resumption on reconnect: %s)", yesNo(*r.ResumptionSupported))
+	}
+	fmt.Fprintf(w, "  Resumed:      %s\n", resumption)
+	fmt.Fprintf(w, "  ECH accepted: %s\n", yesNo(r.ECHAccepted))
+	if r.VerifyError != "" {
+		fmt.Fprintf(w, "  Verification: %s (%s)\n", r.Verification, r.VerifyError)
+	} else {
+		fmt.Fprintf(w, "  Verification: %s\n", r.Verification)
+	}
+
+	if r.OCSPStaple == nil {
+		fmt.Fprintf(w, "  OCSP staple:  none\n")
+	} else if r.OCSPStaple.Error != "" {
+		fmt.Fprintf(w, "  OCSP staple:  %s (%s)\n", r.OCSPStaple.Status, r.OCSPStaple.Error)
+	} else {
+		fmt.Fprintf(w, "  OCSP staple:  %s (produced %s, next update %s)\n", r.OCSPStaple.Status,
+			r.OCSPStaple.ProducedAt.Format(time.RFC3339), formatTime(r.OCSPStaple.NextUpdate))
+	}
+
+	if r.Revocation != nil {
+		fmt.Fprintf(w, "  Revocation:   %s (OCSP %s)\n", r.Revocation.Status, r.Revocation.Source)
+	}
+
+	fmt.Fprintf(w, "  SCTs:         %d\n", len(r.SCTs))
+	for _, sct := range r.SCTs {
+		fmt.Fprintf(w, "    log %s at %s (%s)\n", sct.LogID, sct.Timestamp.Format(time.RFC3339), sct.Source)
+	}
+
+	for i, c := range r.Certificates {
+		fmt.Fprintf(w, "\nCertificate %d\n", i)
+		fmt.Fprintf(w, "  Subject:      %s\n", c.Subject)
+		fmt.Fprintf(w, "  Issuer:       %s\n", c.Issuer)
+		fmt.Fprintf(w, "  Serial:       %s\n", c.SerialNumber)
+		if len(c.SANs) > 0 {
+			fmt.Fprintf(w, "  SANs:         %s\n", strings.Join(c.SANs, ", "))
+		}
+		fmt.Fprintf(w, "  Valid:        %s to %s (%s)\n", c.NotBefore.Format(time.RFC3339),
+			c.NotAfter.Format(time.RFC3339), expiryText(c))
+		fmt.Fprintf(w, "  Key:          %s %d bits\n", c.KeyType, c.KeySize)
+		fmt.Fprintf(w, "  Signature:    %s\n", c.SignatureAlgorithm)
+		fmt.Fprintf(w, "  CA:           %s\n", yesNo(c.IsCA))
+		fmt.Fprintf(w, "  SHA-256:      %s\n", c.SHA256Fingerprint)
+		fmt.Fprintf(w, "  SPKI pin:     %s\n", c.SPKIPin)
+	}
+}
+
+// WriteJSON writes the report as JSON.
+func (r *ConnectionReport) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+func expiryText(c CertificateReport) string {
+	switch {
+	case time.Now().After(c.NotAfter):
+		return fmt.Sprintf("EXPIRED %d days ago", -c.DaysUntilExpiry)
+	case time.Now().Before(c.NotBefore):
+		return "NOT YET VALID"
+	default:
+		return fmt.Sprintf("%d days until expiry", c.DaysUntilExpiry)
+	}
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
@@ -0,0 +1,60 @@
+package transport
+
+import (
+	"bytes"
+	"crypto/tls"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConnectionReportExpiredCertificate(t *testing.T) {
+	cert, err := GenerateCertificate([]string{"localhost"}, -48*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := serveTLS(t, &tls.Config{Certificates: []tls.Certificate{*cert}}, nil)
+
+	opts := TLSOptions{ServerName: "localhost", CAFile: writeCAFile(t, cert.Leaf)}
+	verifier, err := NewTLSWrapper(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The handshake itself must succeed for the chain to be reported.
+	opts.SkipVerify = true
+	wrapper, err := NewTLSWrapper(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := wrapper.DialTLS(func() (net.Conn, error) {
+		return net.Dial("tcp", address)
+	}, 5*time.Second)
+	if err != nil {
+		t.Fatalf("DialTLS: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+	state := conn.(*tls.Conn).ConnectionState()
+
+	report := NewConnectionReport(address, state)
+	report.SetVerification(verifier.Verify(state), false)
+	if report.Verification != "failed" || !strings.Contains(report.VerifyError, "expired") {
+		t.Errorf("verification = %s (%s), want an expiry failure", report.Verification, report.VerifyError)
+	}
+
+	var out bytes.Buffer
+	report.WriteText(&out)
+	for _, want := range []string{"Verification: failed", "EXPIRED 2 days ago"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("report does not contain %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestConnectionReportVerificationSkipped(t *testing.T) {
+	report := &ConnectionReport{}
+	report.SetVerification(nil, true)
+	if report.Verification != "skipped" || report.VerifyError != "" {
+		t.Errorf("verification = %s (%s), want skipped", report.Verification, report.VerifyError)
+	}
+}
@@ -0,0 +1,171 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// OCSP checking modes for TLSOptions.OCSPMode.
+const (
+	OCSPStaple  = "staple"  // check a stapled response if the server sends one
+	OCSPRequire = "require" // require a good stapled response
+	OCSPFetch   = "fetch"   // use the staple, or else query the responder
+)
+
+// ParseOCSPMode validates an OCSP checking mode.
+func ParseOCSPMode(mode string) (string, error) {
+	switch mode {
+	case "", OCSPStaple, OCSPRequire, OCSPFetch:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown OCSP mode: %s (expected %s, %s or %s)", mode, OCSPStaple, OCSPRequire, OCSPFetch)
+	}
+}
+
+// checkRevocation checks the revocation status of leaf according to mode.
+// It returns the response used, if any, even when the check fails. A
+// resumed session need not carry a staple, since the server does not send
+// one on resumption.
+func (t *TLSWrapper) checkRevocation(staple []byte, leaf, issuer *x509.Certificate, resumed bool) (*OCSPReport, error) {
+	if len(staple) > 0 {
+		report := newOCSPReport(staple, leaf, issuer)
+		report.Source = "staple"
+		if report.Error != "" {
+			return report, fmt.Errorf("invalid OCSP staple: %s", report.Error)
+		}
+		return report, ocspStatusError(report)
+	}
+
+	switch t.opts.OCSPMode {
+	case OCSPRequire:
+		if resumed {
+			return nil, nil
+		}
+		return nil, errors.New("server did not staple an OCSP response")
+	case OCSPFetch:
+		report, err := t.fetchOCSP(leaf, issuer)
+		if err != nil {
+			return report, err
+		}
+		return report, ocspStatusError(report)
+	default:
+		return nil, nil
+	}
+}
+
+// fetchOCSP queries the certificate's OCSP responder over HTTP.
+func (t *TLSWrapper) fetchOCSP(leaf, issuer *x509.Certificate) (*OCSPReport, error) {
+	if issuer == nil {
+		return nil, errors.New("cannot query OCSP responder without the issuer certificate")
+	}
+	if len(leaf.OCSPServer) == 0 {
+		return nil, errors.New("certificate names no OCSP responder")
+	}
+
+	req, err := ocsp.CreateRequest(leaf, issuer, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OCSP request: %w", err)
+	}
+
+	timeout := t.opts.OCSPTimeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+
+	transport := &http.Transport{Proxy: nil}
+	if t.opts.OCSPDial != nil {
+		dial := t.opts.OCSPDial
+		transport.DialContext = func(_ context.Context, network, address string) (net.Conn, error) {
+			return dial(network, address)
+		}
+	}
+	client := &http.Client{Transport: transport, Timeout: timeout}
+	defer transport.CloseIdleConnections()
+
+	responder := leaf.OCSPServer[0]
+	if t.opts.Verbose {
+		fmt.Fprintf(os.Stderr, "Querying OCSP responder %s\n", responder)
+	}
+
+	resp, err := client.Post(responder, "application/ocsp-request", bytes.NewReader(req))
+	if err != nil {
+		return nil, fmt.Errorf("OCSP request to %s failed: %w", responder, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("OCSP responder %s returned %s", responder, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read OCSP response: %w", err)
+	}
+
+	report := newOCSPReport(body, leaf, issuer)
+	report.Source = "responder"
+	if report.Error != "" {
+		return report, fmt.Errorf("invalid OCSP response from %s: %s", responder, report.Error)
+	}
+	return report, nil
+}
+
+// ocspStatusError turns a non-good or expired response into an error.
+func ocspStatusError(report *OCSPReport) error {
+	if !report.NextUpdate.IsZero() && time.Now().After(report.NextUpdate) {
+		return fmt.Errorf("OCSP %s response expired at %s", report.Source, report.NextUpdate.Format(time.RFC3339))
+	}
+
+	switch report.Status {
+	case "good":
+		return nil
+	case "revoked":
+		return fmt.Errorf("certificate revoked at %s (OCSP %s)", report.RevokedAt.Format(time.RFC3339), report.Source)
+	default:
+		return fmt.Errorf("certificate status unknown to OCSP %s", report.Source)
+	}
+}
+
+// newOCSPReport parses an OCSP response for leaf, checking its signature
+// against issuer. Without the issuer the response cannot be trusted and is
+// reported as invalid.
+func newOCSPReport(raw []byte, leaf, issuer *x509.Certificate) *OCSPReport {
+	if issuer == nil {
+		return &OCSPReport{Status: "invalid", Error: "cannot verify OCSP response without the issuer certificate"}
+	}
+	resp, err := ocsp.ParseResponseForCert(raw, leaf, issuer)
+	if err != nil {
+		return &OCSPReport{Status: "invalid", Error: err.Error()}
+	}
+
+	r := &OCSPReport{
+		Status:     ocspStatusName(resp.Status),
+		ProducedAt: resp.ProducedAt,
+		ThisUpdate: resp.ThisUpdate,
+		NextUpdate: resp.NextUpdate,
+	}
+	if resp.Status == ocsp.Revoked {
+		r.RevokedAt = &resp.RevokedAt
+	}
+	return r
+}
+
+func ocspStatusName(status int) string {
+	switch status {
+	case ocsp.Good:
+		return "good"
+	case ocsp.Revoked:
+		return "revoked"
+	default:
+		return "unknown"
+	}
+}
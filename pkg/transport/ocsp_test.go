@@ -0,0 +1,245 @@
+package transport
+
+import (
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// ocspPKI is a test CA with a leaf certificate for localhost.
+type ocspPKI struct {
+	ca     *tls.Certificate
+	caFile string
+	leaf   *tls.Certificate
+}
+
+// newOCSPPKI creates a CA and a leaf naming responder as its OCSP server.
+func newOCSPPKI(t *testing.T, responder string) *ocspPKI {
+	t.Helper()
+
+	p := &ocspPKI{ca: newTestCA(t, "Test CA")}
+	leaf, err := issueCertificate(&x509.Certificate{
+		Subject:     pkix.Name{CommonName: "localhost"},
+		DNSNames:    []string{"localhost"},
+		NotBefore:   time.Now().Add(-time.Hour),
+		NotAfter:    time.Now().Add(time.Hour),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		OCSPServer:  []string{responder},
+	}, p.ca.Leaf, p.ca.PrivateKey.(crypto.Signer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.leaf = leaf
+	p.caFile = writeCAFile(t, p.ca.Leaf)
+	return p
+}
+
+// newTestCA creates a self-signed CA certificate.
+func newTestCA(t *testing.T, name string) *tls.Certificate {
+	t.Helper()
+
+	ca, err := issueCertificate(&x509.Certificate{
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ca
+}
+
+// response creates an OCSP response for the leaf with the given status,
+// signed by the CA.
+func (p *ocspPKI) response(t *testing.T, status int) []byte {
+	t.Helper()
+	return p.responseSignedBy(t, status, p.ca)
+}
+
+// responseSignedBy creates an OCSP response for the leaf signed by
+// another responder.
+func (p *ocspPKI) responseSignedBy(t *testing.T, status int, responder *tls.Certificate) []byte {
+	t.Helper()
+
+	template := ocsp.Response{
+		Status:       status,
+		SerialNumber: p.leaf.Leaf.SerialNumber,
+		ThisUpdate:   time.Now().Add(-time.Minute),
+		NextUpdate:   time.Now().Add(time.Hour),
+	}
+	if status == ocsp.Revoked {
+		template.RevokedAt = time.Now().Add(-time.Minute)
+		template.RevocationReason = ocsp.KeyCompromise
+	}
+	if responder != p.ca {
+		template.Certificate = responder.Leaf
+	}
+
+	raw, err := ocsp.CreateResponse(p.ca.Leaf, responder.Leaf, template, responder.PrivateKey.(crypto.Signer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+// serve starts a TLS server presenting the leaf with the given staple.
+func (p *ocspPKI) serve(t *testing.T, staple []byte) string {
+	t.Helper()
+
+	leaf := *p.leaf
+	leaf.OCSPStaple = staple
+	return serveTLS(t, &tls.Config{Certificates: []tls.Certificate{leaf}}, nil)
+}
+
+// dialOCSP connects to localhost at address, returning the wrapper so that
+// its OCSP result can be inspected.
+func dialOCSP(t *testing.T, address string, opts TLSOptions) (*TLSWrapper, error) {
+	t.Helper()
+
+	opts.ServerName = "localhost"
+	wrapper, err := NewTLSWrapper(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := wrapper.DialTLS(func() (net.Conn, error) {
+		return net.Dial("tcp", address)
+	}, 5*time.Second)
+	if err == nil {
+		_ = conn.Close()
+	}
+	return wrapper, err
+}
+
+func TestOCSPStaple(t *testing.T) {
+	p := newOCSPPKI(t, "http://127.0.0.1:1/")
+	other := newTestCA(t, "Other CA")
+
+	tests := []struct {
+		name    string
+		staple  []byte
+		status  string
+		wantErr string
+	}{
+		{"good", p.response(t, ocsp.Good), "good", ""},
+		{"revoked", p.response(t, ocsp.Revoked), "revoked", "certificate revoked"},
+		{"unknown", p.response(t, ocsp.Unknown), "unknown", "status unknown"},
+		{"bad signature", p.responseSignedBy(t, ocsp.Good, other), "invalid", "invalid OCSP staple"},
+		{"missing", nil, "", "server did not staple an OCSP response"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			address := p.serve(t, tt.staple)
+			wrapper, err := dialOCSP(t, address, TLSOptions{CAFile: p.caFile, OCSPMode: OCSPRequire})
+
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("DialTLS: %v", err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, want %q", err, tt.wantErr)
+			}
+
+			report := wrapper.OCSPResult()
+			switch {
+			case tt.status == "" && report != nil:
+				t.Errorf("got an OCSP report with status %s, want none", report.Status)
+			case tt.status != "" && report == nil:
+				t.Errorf("got no OCSP report, want status %s", tt.status)
+			case tt.status != "" && report.Status != tt.status:
+				t.Errorf("status = %s, want %s", report.Status, tt.status)
+			}
+		})
+	}
+}
+
+func TestOCSPStapleWithoutIssuer(t *testing.T) {
+	p := newOCSPPKI(t, "http://127.0.0.1:1/")
+	address := p.serve(t, p.response(t, ocsp.Good))
+
+	// With -k the chain is not built, and the server sends no issuer.
+	wrapper, err := dialOCSP(t, address, TLSOptions{SkipVerify: true, OCSPMode: OCSPStaple})
+	if err == nil || !strings.Contains(err.Error(), "without the issuer certificate") {
+		t.Fatalf("error = %v, want a missing issuer error", err)
+	}
+	if report := wrapper.OCSPResult(); report != nil && report.Status == "good" {
+		t.Error("unverified staple reported as good")
+	}
+}
+
+func TestOCSPFetch(t *testing.T) {
+	var p *ocspPKI
+	var revoked []byte
+	responder := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		req, err := ocsp.ParseRequest(body)
+		if err != nil || req.SerialNumber.Cmp(p.leaf.Leaf.SerialNumber) != 0 {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/ocsp-response")
+		_, _ = w.Write(revoked)
+	}))
+	defer responder.Close()
+
+	p = newOCSPPKI(t, responder.URL)
+	revoked = p.response(t, ocsp.Revoked)
+	address := p.serve(t, nil)
+
+	wrapper, err := dialOCSP(t, address, TLSOptions{CAFile: p.caFile, OCSPMode: OCSPFetch})
+	if err == nil || !strings.Contains(err.Error(), "certificate revoked") {
+		t.Fatalf("error = %v, want a revocation error", err)
+	}
+	report := wrapper.OCSPResult()
+	if report == nil || report.Source != "responder" || report.Status != "revoked" {
+		t.Errorf("report = %+v, want revoked from the responder", report)
+	}
+}
+
+func TestOCSPRequireResumedSession(t *testing.T) {
+	p := newOCSPPKI(t, "http://127.0.0.1:1/")
+	// TLS 1.2 delivers the session ticket within the handshake.
+	address := serveTLS(t, &tls.Config{
+		Certificates: []tls.Certificate{*p.leaf},
+		MaxVersion:   tls.VersionTLS12,
+	}, nil)
+	cache := tls.NewLRUClientSessionCache(1)
+
+	// A session without a staple, established without requiring one.
+	if _, err := dialOCSP(t, address, TLSOptions{CAFile: p.caFile, OCSPMode: OCSPStaple, SessionCache: cache}); err != nil {
+		t.Fatalf("DialTLS: %v", err)
+	}
+
+	wrapper, err := NewTLSWrapper(TLSOptions{ServerName: "localhost", CAFile: p.caFile, OCSPMode: OCSPRequire, SessionCache: cache})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := wrapper.DialTLS(func() (net.Conn, error) {
+		return net.Dial("tcp", address)
+	}, 5*time.Second)
+	if err != nil {
+		t.Fatalf("resumed session refused: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+	if !conn.(*tls.Conn).ConnectionState().DidResume {
+		t.Fatal("session was not resumed")
+	}
+}
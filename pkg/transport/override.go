@@ -0,0 +1,125 @@
+package transport
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// AddressOverrides redirects connections to other addresses, like curl's
+// --connect-to and --resolve, while the original name is kept for TLS and
+// HTTP. A nil *AddressOverrides rewrites nothing.
+type AddressOverrides struct {
+	connectTo []connectToRule
+	resolve   map[string][]string // lowercased host:port -> IPs
+}
+
+// connectToRule maps host:port to newhost:newport. Empty fields match any
+// host or port, or keep the original one.
+type connectToRule struct {
+	host, port       string
+	newHost, newPort string
+}
+
+// NewAddressOverrides parses --resolve (host:port:addr[,addr...]) and
+// --connect-to (host:port:newhost:newport) rules.
+func NewAddressOverrides(resolve, connectTo []string) (*AddressOverrides, error) {
+	if len(resolve) == 0 && len(connectTo) == 0 {
+		return nil, nil
+	}
+
+	o := &AddressOverrides{resolve: make(map[string][]string)}
+
+	for _, spec := range resolve {
+		fields := splitOverride(spec, 3)
+		if len(fields) != 3 || fields[0] == "" || fields[1] == "" || fields[2] == "" {
+			return nil, fmt.Errorf("invalid --resolve %q: expected host:port:addr[,addr...]", spec)
+		}
+		var ips []string
+		for _, addr := range strings.Split(fields[2], ",") {
+			addr = strings.Trim(strings.TrimSpace(addr), "[]")
+			if net.ParseIP(addr) == nil {
+				return nil, fmt.Errorf("invalid --resolve %q: %q is not an IP address", spec, addr)
+			}
+			ips = append(ips, addr)
+		}
+		key := strings.ToLower(net.JoinHostPort(fields[0], fields[1]))
+		o.resolve[key] = append(o.resolve[key], ips...)
+	}
+
+	for _, spec := range connectTo {
+		fields := splitOverride(spec, 4)
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("invalid --connect-to %q: expected host:port:newhost:newport", spec)
+		}
+		o.connectTo = append(o.connectTo, connectToRule{
+			host:    strings.ToLower(fields[0]),
+			port:    fields[1],
+			newHost: fields[2],
+			newPort: fields[3],
+		})
+	}
+
+	return o, nil
+}
+
+// splitOverride splits s on colons outside brackets into at most n fields,
+// removing the brackets around IPv6 hosts.
+func splitOverride(s string, n int) []string {
+	var fields []string
+	depth, start := 0, 0
+	for i := 0; i < len(s) && len(fields) < n-1; i++ {
+		switch s[i] {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case ':':
+			if depth == 0 {
+				fields = append(fields, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	fields = append(fields, s[start:])
+
+	for i := range fields[:len(fields)-1] {
+		fields[i] = strings.TrimSuffix(strings.TrimPrefix(fields[i], "["), "]")
+	}
+	return fields
+}
+
+// Rewrite returns the addresses to connect to instead of address: the
+// first matching --connect-to rule is applied, then --resolve. If nothing
+// matches, address itself is returned.
+func (o *AddressOverrides) Rewrite(address string) []string {
+	if o == nil {
+		return []string{address}
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return []string{address}
+	}
+
+	for _, rule := range o.connectTo {
+		if (rule.host == "" || rule.host == strings.ToLower(host)) && (rule.port == "" || rule.port == port) {
+			if rule.newHost != "" {
+				host = rule.newHost
+			}
+			if rule.newPort != "" {
+				port = rule.newPort
+			}
+			break
+		}
+	}
+
+	ips, ok := o.resolve[strings.ToLower(net.JoinHostPort(host, port))]
+	if !ok {
+		return []string{net.JoinHostPort(host, port)}
+	}
+	addresses := make([]string, len(ips))
+	for i, ip := range ips {
+		addresses[i] = net.JoinHostPort(ip, port)
+	}
+	return addresses
+}
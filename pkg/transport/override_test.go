@@ -0,0 +1,61 @@
+package transport
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestAddressOverridesRewrite(t *testing.T) {
+	tests := []struct {
+		name      string
+		resolve   []string
+		connectTo []string
+		address   string
+		want      []string
+	}{
+		{"no match", []string{"a.example:443:192.0.2.1"}, nil, "b.example:443", []string{"b.example:443"}},
+		{"resolve", []string{"a.example:443:192.0.2.1"}, nil, "a.example:443", []string{"192.0.2.1:443"}},
+		{"resolve other port", []string{"a.example:443:192.0.2.1"}, nil, "a.example:80", []string{"a.example:80"}},
+		{"resolve case insensitive", []string{"A.Example:443:192.0.2.1"}, nil, "a.EXAMPLE:443", []string{"192.0.2.1:443"}},
+		{"multiple addresses", []string{"a.example:443:192.0.2.1,[2001:db8::1]"}, nil, "a.example:443",
+			[]string{"192.0.2.1:443", "[2001:db8::1]:443"}},
+		{"repeated rules", []string{"a.example:443:192.0.2.1", "a.example:443:192.0.2.2"}, nil, "a.example:443",
+			[]string{"192.0.2.1:443", "192.0.2.2:443"}},
+		{"connect-to", nil, []string{"a.example:443:b.example:8443"}, "a.example:443", []string{"b.example:8443"}},
+		{"connect-to any port", nil, []string{"a.example::b.example:"}, "a.example:8080", []string{"b.example:8080"}},
+		{"connect-to any host", nil, []string{":443::8443"}, "c.example:443", []string{"c.example:8443"}},
+		{"connect-to IPv6", nil, []string{"a.example:443:[2001:db8::2]:443"}, "a.example:443", []string{"[2001:db8::2]:443"}},
+		{"first connect-to wins", nil, []string{"a.example:443:b.example:", "a.example::c.example:"}, "a.example:443",
+			[]string{"b.example:443"}},
+		{"connect-to before resolve",
+			[]string{"b.example:8443:192.0.2.3", "a.example:443:192.0.2.1"},
+			[]string{"a.example:443:b.example:8443"},
+			"a.example:443", []string{"192.0.2.3:8443"}},
+		{"no port", []string{"a.example:443:192.0.2.1"}, nil, "a.example", []string{"a.example"}},
+	}
+
+	for _, tt := range tests {
+		o, err := NewAddressOverrides(tt.resolve, tt.connectTo)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got := o.Rewrite(tt.address); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: Rewrite(%s) = %v, want %v", tt.name, tt.address, got, tt.want)
+		}
+	}
+}
+
+func TestNewAddressOverridesInvalid(t *testing.T) {
+	for _, spec := range []string{"a.example:443", "a.example::192.0.2.1", "a.example:443:not-an-ip"} {
+		if _, err := NewAddressOverrides([]string{spec}, nil); err == nil {
+			t.Errorf("--resolve %q accepted", spec)
+		}
+	}
+	if _, err := NewAddressOverrides(nil, []string{"a.example:443:b.example"}); err == nil {
+		t.Error("--connect-to with three fields accepted")
+	}
+	if o, err := NewAddressOverrides(nil, nil); o != nil || err != nil {
+		t.Errorf("no rules = (%v, %v), want nil", o, err)
+	}
+}
@@ -0,0 +1,31 @@
+package transport
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// PeerCredentials describes the process on the other end of a Unix socket
+// connection, e.g. "pid 1234, uid 1000, gid 1000", as reported by
+// SO_PEERCRED. conn may be wrapped, e.g. in TLS. It returns "" if they
+// are not available.
+func PeerCredentials(conn net.Conn) string {
+	unixConn, ok := underlyingConn(conn).(*net.UnixConn)
+	if !ok {
+		return ""
+	}
+	raw, err := unixConn.SyscallConn()
+	if err != nil {
+		return ""
+	}
+
+	var cred *syscall.Ucred
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	}); err != nil || credErr != nil {
+		return ""
+	}
+	return fmt.Sprintf("pid %d, uid %d, gid %d", cred.Pid, cred.Uid, cred.Gid)
+}
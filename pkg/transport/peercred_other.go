@@ -0,0 +1,11 @@
+//go:build !linux
+
+package transport
+
+import "net"
+
+// PeerCredentials describes the process on the other end of a Unix socket
+// connection. Peer credentials are only supported on Linux.
+func PeerCredentials(net.Conn) string {
+	return ""
+}
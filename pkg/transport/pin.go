@@ -0,0 +1,69 @@
+package transport
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+const pinPrefix = "sha256//"
+
+// ParsePins decodes public key pins of the form sha256//BASE64. Several
+// pins may also be given in one string separated by ';' (curl style).
+func ParsePins(pins []string) ([][]byte, error) {
+	var hashes [][]byte
+	for _, entry := range pins {
+		for _, pin := range strings.Split(entry, ";") {
+			pin = strings.TrimSpace(pin)
+			if pin == "" {
+				continue
+			}
+			if !strings.HasPrefix(pin, pinPrefix) {
+				return nil, fmt.Errorf("invalid pin %q: must start with %s", pin, pinPrefix)
+			}
+			hash, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(pin, pinPrefix))
+			if err != nil || len(hash) != sha256.Size {
+				return nil, fmt.Errorf("invalid pin %q: expected a base64 SHA-256 hash", pin)
+			}
+			hashes = append(hashes, hash)
+		}
+	}
+	return hashes, nil
+}
+
+// SPKIHash returns the SHA-256 hash of a certificate's SubjectPublicKeyInfo.
+func SPKIHash(cert *x509.Certificate) []byte {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return sum[:]
+}
+
+// FormatPin formats a SPKI hash as a sha256//BASE64 pin.
+func FormatPin(hash []byte) string {
+	return pinPrefix + base64.StdEncoding.EncodeToString(hash)
+}
+
+// matchPins returns the first certificate whose public key matches one of
+// the pins, or nil.
+func matchPins(pins [][]byte, certs []*x509.Certificate) *x509.Certificate {
+	for _, cert := range certs {
+		hash := SPKIHash(cert)
+		for _, pin := range pins {
+			if bytes.Equal(hash, pin) {
+				return cert
+			}
+		}
+	}
+	return nil
+}
+
+// describePins formats the pins of a chain, one certificate per line.
+func describePins(certs []*x509.Certificate) string {
+	var b strings.Builder
+	for i, cert := range certs {
+		fmt.Fprintf(&b, "  %d %s %s\n", i, FormatPin(SPKIHash(cert)), cert.Subject)
+	}
+	return b.String()
+}
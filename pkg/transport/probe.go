@@ -0,0 +1,206 @@
+package transport
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ProbeReport lists the protocol versions and cipher suites a server accepts.
+type ProbeReport struct {
+	Address  string          `json:"address"`
+	Versions []VersionResult `json:"versions"`
+}
+
+// VersionResult is the outcome of probing a single protocol version.
+type VersionResult struct {
+	Version      string        `json:"version"`
+	Supported    bool          `json:"supported"`
+	Error        string        `json:"error,omitempty"`
+	VerifyError  string        `json:"verify_error,omitempty"`
+	CipherSuites []SuiteResult `json:"cipher_suites,omitempty"`
+}
+
+// SuiteResult is the outcome of probing a single cipher suite.
+type SuiteResult struct {
+	Name     string `json:"name"`
+	ID       string `json:"id"`
+	Insecure bool   `json:"insecure"`
+	Accepted bool   `json:"accepted"`
+}
+
+// ProbeTLS handshakes with a server once per protocol version, and once per
+// cipher suite for TLS 1.0-1.2, reporting which ones are accepted. TLS 1.3
+// suites cannot be restricted, so only the negotiated one is reported.
+//
+// dial must open a fresh connection for every attempt. The handshakes do
+// not verify the certificate, so that a version is reported as supported
+// even if the certificate is not trusted; verification failures against
+// the configured roots and pins are reported separately.
+func ProbeTLS(address string, dial func() (net.Conn, error), opts TLSOptions, timeout time.Duration) *ProbeReport {
+	verbose := opts.Verbose
+	opts.Verbose = false
+	opts.RequireALPN = false
+	opts.KeyLogFile = ""
+	opts.OCSPMode = ""
+	opts.ECHConfigList = nil
+	opts.ECHRetry = false
+	// Every attempt needs a full handshake.
+	opts.SessionCache = nil
+
+	verifier, verifierErr := NewTLSWrapper(opts)
+	opts.SkipVerify = true
+	opts.Pins = nil
+
+	minVersion, maxVersion := opts.MinVersion, opts.MaxVersion
+	if minVersion == 0 {
+		minVersion = tls.VersionTLS10
+	}
+	if maxVersion == 0 {
+		maxVersion = tls.VersionTLS13
+	}
+
+	suites := opts.CipherSuites
+	if len(suites) == 0 {
+		for _, s := range allCipherSuites() {
+			suites = append(suites, s.ID)
+		}
+	}
+
+	report := &ProbeReport{Address: address}
+	for _, version := range tlsVersions {
+		if version < minVersion || version > maxVersion {
+			continue
+		}
+
+		if verbose {
+			fmt.Fprintf(os.Stderr, "Probing %s...\n", tls.VersionName(version))
+		}
+
+		attempt := opts
+		attempt.MinVersion, attempt.MaxVersion = version, version
+		attempt.CipherSuites = nil
+		result := VersionResult{Version: tls.VersionName(version)}
+
+		state, err := probeHandshake(dial, attempt, timeout)
+		if err != nil {
+			result.Error = err.Error()
+			report.Versions = append(report.Versions, result)
+			continue
+		}
+		result.Supported = true
+		verifyErr := verifierErr
+		if verifyErr == nil {
+			verifyErr = verifier.verifyConnection(state)
+		}
+		if verifyErr != nil {
+			// Only the first line; the rest describes the chain.
+			result.VerifyError = strings.SplitN(verifyErr.Error(), "\n", 2)[0]
+		}
+
+		if version == tls.VersionTLS13 {
+			result.CipherSuites = append(result.CipherSuites, suiteResult(state.CipherSuite, true))
+			report.Versions = append(report.Versions, result)
+			continue
+		}
+
+		for _, id := range suites {
+			if !suiteSupportsVersion(id, version) {
+				continue
+			}
+			attempt.CipherSuites = []uint16{id}
+			_, err := probeHandshake(dial, attempt, timeout)
+			if verbose {
+				fmt.Fprintf(os.Stderr, "  %s: %v\n", tls.CipherSuiteName(id), err == nil)
+			}
+			result.CipherSuites = append(result.CipherSuites, suiteResult(id, err == nil))
+		}
+		report.Versions = append(report.Versions, result)
+	}
+
+	return report
+}
+
+// probeHandshake performs a single handshake attempt and closes the connection.
+func probeHandshake(dial func() (net.Conn, error), opts TLSOptions, timeout time.Duration) (tls.ConnectionState, error) {
+	wrapper, err := NewTLSWrapper(opts)
+	if err != nil {
+		return tls.ConnectionState{}, err
+	}
+
+	conn, err := dial()
+	if err != nil {
+		return tls.ConnectionState{}, err
+	}
+
+	tlsConn, err := wrapper.Wrap(conn, timeout)
+	if err != nil {
+		return tls.ConnectionState{}, err
+	}
+	defer func() { _ = tlsConn.Close() }()
+
+	return tlsConn.(*tls.Conn).ConnectionState(), nil
+}
+
+func suiteSupportsVersion(id, version uint16) bool {
+	for _, s := range allCipherSuites() {
+		if s.ID == id {
+			return slices.Contains(s.SupportedVersions, version)
+		}
+	}
+	return false
+}
+
+func suiteResult(id uint16, accepted bool) SuiteResult {
+	insecure := false
+	for _, s := range tls.InsecureCipherSuites() {
+		if s.ID == id {
+			insecure = true
+		}
+	}
+	return SuiteResult{
+		Name:     tls.CipherSuiteName(id),
+		ID:       fmt.Sprintf("0x%04x", id),
+		Insecure: insecure,
+		Accepted: accepted,
+	}
+}
+
+// WriteText writes a human-readable summary listing accepted suites.
+func (r *ProbeReport) WriteText(w io.Writer) {
+	fmt.Fprintf(w, "TLS probe of %s\n", r.Address)
+	for _, v := range r.Versions {
+		if !v.Supported {
+			fmt.Fprintf(w, "%s: not supported (%s)\n", v.Version, v.Error)
+			continue
+		}
+		if v.VerifyError != "" {
+			fmt.Fprintf(w, "%s: supported (verification failed: %s)\n", v.Version, v.VerifyError)
+		} else {
+			fmt.Fprintf(w, "%s: supported\n", v.Version)
+		}
+		for _, s := range v.CipherSuites {
+			if !s.Accepted {
+				continue
+			}
+			note := ""
+			if s.Insecure {
+				note = " (insecure)"
+			}
+			fmt.Fprintf(w, "  %s%s\n", s.Name, note)
+		}
+	}
+}
+
+// WriteJSON writes the full report, including rejected suites, as JSON.
+func (r *ProbeReport) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
@@ -0,0 +1,50 @@
+package transport
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestProbeTLSReportsVerificationSeparately(t *testing.T) {
+	cert, err := GenerateCertificate([]string{"localhost"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := serveTLS(t, &tls.Config{
+		Certificates: []tls.Certificate{*cert},
+		MinVersion:   tls.VersionTLS12,
+	}, nil)
+	caFile := writeCAFile(t, cert.Leaf)
+	dial := func() (net.Conn, error) { return net.Dial("tcp", address) }
+
+	tests := []struct {
+		name       string
+		opts       TLSOptions
+		wantVerify bool
+	}{
+		{"trusted", TLSOptions{CAFile: caFile}, false},
+		{"matching pin", TLSOptions{SkipVerify: true, Pins: []string{FormatPin(SPKIHash(cert.Leaf))}}, false},
+		{"pin mismatch", TLSOptions{CAFile: caFile, Pins: []string{FormatPin(make([]byte, 32))}}, true},
+		{"untrusted", TLSOptions{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.ServerName = "localhost"
+			tt.opts.SessionCache = tls.NewLRUClientSessionCache(8)
+			report := ProbeTLS(address, dial, tt.opts, 5*time.Second)
+
+			for _, v := range report.Versions {
+				wantSupported := v.Version == "TLS 1.2" || v.Version == "TLS 1.3"
+				if v.Supported != wantSupported {
+					t.Errorf("%s: supported = %v (%s), want %v", v.Version, v.Supported, v.Error, wantSupported)
+				}
+				if v.Supported && (v.VerifyError != "") != tt.wantVerify {
+					t.Errorf("%s: verify error = %q", v.Version, v.VerifyError)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,543 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// DefaultQUICALPN is offered and accepted over QUIC when no ALPN protocol
+// is configured: QUIC requires ALPN, and raw stream services have no
+// standard protocol name.
+const DefaultQUICALPN = "go-connect"
+
+// maxEarlyData is the most data buffered for resending while 0-RTT data
+// may still be rejected. Writes past it wait for the handshake.
+const maxEarlyData = 64 << 10
+
+// quicConfig returns the QUIC settings for dialing and listening.
+func quicConfig(handshakeTimeout time.Duration) *quic.Config {
+	return &quic.Config{
+		HandshakeIdleTimeout: handshakeTimeout,
+		// Keep quiet sessions open, as a TCP connection would stay.
+		KeepAlivePeriod: 15 * time.Second,
+		Allow0RTT:       true,
+	}
+}
+
+// DialQUIC connects to address over QUIC and opens a bidirectional stream,
+// returned as a net.Conn. Closing it closes the QUIC connection.
+func DialQUIC(address string, timeout time.Duration, dial DialOptions, opts TLSOptions) (net.Conn, error) {
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+
+	if opts.ServerName == "" {
+		host, _, _ := net.SplitHostPort(address)
+		opts.ServerName = host
+	}
+	if len(opts.ALPN) == 0 {
+		opts.ALPN = []string{DefaultQUICALPN}
+	}
+
+	wrapper, err := NewTLSWrapper(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Verbose {
+		fmt.Fprintf(os.Stderr, "Connecting to %s with QUIC\n", address)
+	}
+
+	return wrapper.DialQUIC(address, timeout, dial)
+}
+
+// DialQUIC connects to address over QUIC, using the TLS settings of the
+// wrapper, and opens a bidirectional stream. If a stored session allows
+// it, the stream is usable at once and the first data is sent as 0-RTT
+// early data; should the server reject it, that data is sent again once
+// the handshake completes.
+func (t *TLSWrapper) DialQUIC(address string, timeout time.Duration, dial DialOptions) (net.Conn, error) {
+	socket, addr, err := dial.listenUDP(address, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("QUIC connection failed: %w", err)
+	}
+
+	if t.opts.Verbose {
+		t.reportStart()
+	}
+
+	certRequested := false
+	config := t.config(&certRequested)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	start := time.Now()
+	conn, err := quic.DialEarly(ctx, socket, addr, config, quicConfig(timeout))
+	if err != nil {
+		_ = socket.Close()
+		return nil, fmt.Errorf("QUIC handshake failed: %w", err)
+	}
+
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		_ = conn.CloseWithError(0, "")
+		_ = socket.Close()
+		return nil, fmt.Errorf("failed to open QUIC stream: %w", err)
+	}
+
+	c := &quicStreamConn{
+		conn:    conn,
+		socket:  socket,
+		stream:  stream,
+		timeout: timeout,
+	}
+
+	if t.opts.Verbose {
+		fmt.Fprintf(os.Stderr, "QUIC connection to %s at %s from %s, stream %d\n",
+			address, conn.RemoteAddr(), conn.LocalAddr(), stream.StreamID())
+	}
+
+	select {
+	case <-conn.HandshakeComplete():
+		if t.opts.Verbose {
+			t.reportQUIC(conn, time.Since(start), certRequested, -1)
+		}
+	default:
+		// DialEarly returns before the handshake completes only when
+		// resuming a session that allows early data.
+		if t.opts.Verbose {
+			fmt.Fprintln(os.Stderr, "Sending early data (0-RTT) while the handshake completes")
+		}
+		c.buffering = true
+		c.handshakeDone = make(chan struct{})
+		go c.awaitHandshake(func(early int) {
+			if t.opts.Verbose {
+				t.reportQUIC(conn, time.Since(start), certRequested, early)
+			}
+		})
+	}
+
+	return c, nil
+}
+
+// reportQUIC prints the outcome of a QUIC handshake. early is the number
+// of bytes sent as 0-RTT data, or -1 if none could be sent.
+func (t *TLSWrapper) reportQUIC(conn *quic.Conn, elapsed time.Duration, certRequested bool, early int) {
+	if err := context.Cause(conn.Context()); err != nil {
+		fmt.Fprintf(os.Stderr, "QUIC handshake failed: %v\n", err)
+		return
+	}
+
+	state := conn.ConnectionState()
+	fmt.Fprintf(os.Stderr, "QUIC handshake completed in %v (QUIC %s)\n", elapsed.Round(time.Millisecond), state.Version)
+	t.report(state.TLS, certRequested)
+
+	switch {
+	case early >= 0 && state.Used0RTT:
+		fmt.Fprintf(os.Stderr, "0-RTT: accepted, %d bytes sent as early data\n", early)
+	case early > 0:
+		fmt.Fprintf(os.Stderr, "0-RTT: rejected by the server, sending %d bytes again\n", early)
+	case early == 0:
+		fmt.Fprintln(os.Stderr, "0-RTT: rejected by the server")
+	case state.TLS.DidResume:
+		fmt.Fprintln(os.Stderr, "0-RTT: not attempted (the session does not allow early data)")
+	default:
+		fmt.Fprintln(os.Stderr, "0-RTT: not attempted (no session to resume)")
+	}
+}
+
+// listenUDP resolves address with the overrides, resolver and family, and
+// opens an unconnected UDP socket with the local binding and socket
+// options: QUIC sends its datagrams itself.
+func (o DialOptions) listenUDP(address string, timeout time.Duration) (net.PacketConn, *net.UDPAddr, error) {
+	targets := o.Overrides.Rewrite(address)
+	if o.Verbose && (len(targets) > 1 || targets[0] != address) {
+		fmt.Fprintf(os.Stderr, "Redirecting %s to %s\n", address, targets[0])
+	}
+
+	host, port, err := net.SplitHostPort(targets[0])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	resolver := net.DefaultResolver
+	if o.Resolver != nil {
+		resolver = o.Resolver.NetResolver()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	ips, err := resolver.LookupNetIP(ctx, "ip"+o.Family, host)
+	if err != nil {
+		return nil, nil, err
+	}
+	portNum, err := resolver.LookupPort(ctx, "udp", port)
+	if err != nil {
+		return nil, nil, err
+	}
+	ip := ips[0].Unmap()
+	addr := net.UDPAddrFromAddrPort(netip.AddrPortFrom(ip, uint16(portNum)))
+
+	network := "udp6"
+	if ip.Is4() {
+		network = "udp4"
+	}
+	dialer, err := o.Bind.Dialer(network, timeout)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	lc := net.ListenConfig{Control: dialer.Control}
+	if o.Socket.needsControl() {
+		lc.Control = func(network, address string, c syscall.RawConn) error {
+			if dialer.Control != nil {
+				if err := dialer.Control(network, address, c); err != nil {
+					return err
+				}
+			}
+			return o.Socket.control(network, c)
+		}
+	}
+
+	local := ""
+	if dialer.LocalAddr != nil {
+		local = dialer.LocalAddr.String()
+	}
+	socket, err := lc.ListenPacket(ctx, network, local)
+	if err != nil {
+		return nil, nil, err
+	}
+	return socket, addr, nil
+}
+
+// QUICListener accepts QUIC connections and returns the first
+// bidirectional stream of each as a net.Conn.
+type QUICListener struct {
+	ln      *quic.EarlyListener
+	verbose bool
+	timeout time.Duration // for the first stream and the handshake
+
+	conns chan net.Conn
+	done  chan struct{} // closed once ln fails, with err set
+	err   error
+}
+
+// ListenQUIC listens for QUIC connections on the UDP address. Without ALPN
+// protocols in config, DefaultQUICALPN is accepted.
+func ListenQUIC(address string, config *tls.Config, verbose bool) (*QUICListener, error) {
+	return listenQUIC(address, config, verbose, 30*time.Second)
+}
+
+func listenQUIC(address string, config *tls.Config, verbose bool, timeout time.Duration) (*QUICListener, error) {
+	if len(config.NextProtos) == 0 {
+		config = config.Clone()
+		config.NextProtos = []string{DefaultQUICALPN}
+	}
+
+	ln, err := quic.ListenAddrEarly(address, config, quicConfig(0))
+	if err != nil {
+		return nil, err
+	}
+	l := &QUICListener{
+		ln:      ln,
+		verbose: verbose,
+		timeout: timeout,
+		conns:   make(chan net.Conn),
+		done:    make(chan struct{}),
+	}
+	go l.acceptLoop()
+	return l, nil
+}
+
+// Accept returns the next connection whose first stream has been opened
+// and whose handshake has completed. Connections that fail before that,
+// or take over 30 seconds for it, are reported in verbose mode and
+// skipped.
+func (l *QUICListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		return nil, l.err
+	}
+}
+
+// acceptLoop accepts connections and waits for their first stream in a
+// goroutine each, so that a client that opens none blocks no other.
+func (l *QUICListener) acceptLoop() {
+	for {
+		conn, err := l.ln.Accept(context.Background())
+		if err != nil {
+			l.err = err
+			close(l.done)
+			return
+		}
+		go l.serve(conn)
+	}
+}
+
+// serve passes the first stream of conn to Accept.
+func (l *QUICListener) serve(conn *quic.Conn) {
+	ctx, cancel := context.WithTimeout(conn.Context(), l.timeout)
+	defer cancel()
+
+	c, err := l.acceptStream(ctx, conn)
+	if err != nil {
+		if l.verbose {
+			fmt.Fprintf(os.Stderr, "QUIC connection from %s failed: %v\n", conn.RemoteAddr(), err)
+		}
+		_ = conn.CloseWithError(0, "")
+		return
+	}
+
+	select {
+	case l.conns <- c:
+	case <-l.done:
+		_ = c.Close()
+	}
+}
+
+// acceptStream waits for the client to open a stream, which it announces
+// with its first data, then for the handshake to complete so that the
+// client is verified before any data is relayed.
+func (l *QUICListener) acceptStream(ctx context.Context, conn *quic.Conn) (net.Conn, error) {
+	if l.verbose {
+		fmt.Fprintf(os.Stderr, "QUIC connection from %s, waiting for a stream\n", conn.RemoteAddr())
+	}
+
+	stream, err := conn.AcceptStream(ctx)
+	if err != nil {
+		if conn.Context().Err() == nil && errors.Is(err, context.DeadlineExceeded) {
+			return nil, fmt.Errorf("no stream opened within %v", l.timeout)
+		}
+		return nil, err
+	}
+
+	select {
+	case <-conn.HandshakeComplete():
+	case <-ctx.Done():
+	}
+	if err := context.Cause(conn.Context()); err != nil {
+		return nil, err
+	}
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("handshake not completed within %v", l.timeout)
+	}
+
+	if l.verbose {
+		state := conn.ConnectionState()
+		early := "not used"
+		if state.Used0RTT {
+			early = "accepted"
+		}
+		fmt.Fprintf(os.Stderr, "QUIC %s handshake completed, stream %d, 0-RTT %s\n",
+			state.Version, stream.StreamID(), early)
+	}
+
+	return &quicStreamConn{conn: conn, stream: stream}, nil
+}
+
+// Close stops listening. Accepted connections stay open.
+func (l *QUICListener) Close() error {
+	return l.ln.Close()
+}
+
+// Addr returns the local UDP address.
+func (l *QUICListener) Addr() net.Addr {
+	return l.ln.Addr()
+}
+
+// quicStreamConn is a bidirectional QUIC stream used as a net.Conn.
+// Closing it closes the QUIC connection, and its socket if the
+// connection has one to itself.
+type quicStreamConn struct {
+	conn    *quic.Conn
+	socket  net.PacketConn
+	timeout time.Duration
+
+	mu     sync.Mutex
+	stream *quic.Stream
+	// While buffering, written data is kept in early, as it may have been
+	// sent as 0-RTT data that the server rejects. handshakeDone is closed
+	// once that is known.
+	buffering     bool
+	early         []byte
+	handshakeDone chan struct{}
+	readDeadline  time.Time
+	writeDeadline time.Time
+
+	once sync.Once
+}
+
+// awaitHandshake stops buffering once the handshake has completed with
+// the early data accepted, then calls done with the number of bytes sent
+// early. Rejected data stays buffered for resend.
+func (c *quicStreamConn) awaitHandshake(done func(early int)) {
+	select {
+	case <-c.conn.HandshakeComplete():
+	case <-c.conn.Context().Done():
+	}
+
+	c.mu.Lock()
+	early := len(c.early)
+	if c.conn.ConnectionState().Used0RTT {
+		c.buffering = false
+		c.early = nil
+	}
+	c.mu.Unlock()
+	close(c.handshakeDone)
+
+	done(early)
+}
+
+func (c *quicStreamConn) Read(b []byte) (int, error) {
+	c.mu.Lock()
+	stream := c.stream
+	c.mu.Unlock()
+
+	n, err := stream.Read(b)
+	if errors.Is(err, quic.Err0RTTRejected) {
+		if stream, err = c.resend(stream); err != nil {
+			return 0, err
+		}
+		return stream.Read(b)
+	}
+	return n, err
+}
+
+func (c *quicStreamConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	stream := c.stream
+	if c.buffering && len(c.early)+len(b) > maxEarlyData {
+		c.mu.Unlock()
+		if err := c.waitHandshake(stream); err != nil {
+			return 0, err
+		}
+		c.mu.Lock()
+		stream = c.stream
+	}
+	if c.buffering {
+		c.early = append(c.early, b...)
+	}
+	c.mu.Unlock()
+
+	n, err := stream.Write(b)
+	if errors.Is(err, quic.Err0RTTRejected) {
+		// b is buffered, so resend delivers it.
+		if _, err = c.resend(stream); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	return n, err
+}
+
+// waitHandshake blocks until the handshake has completed and, if the
+// early data was rejected, sends it again, so that writing can go on
+// without buffering.
+func (c *quicStreamConn) waitHandshake(stream *quic.Stream) error {
+	<-c.handshakeDone
+
+	c.mu.Lock()
+	buffering := c.buffering
+	c.mu.Unlock()
+	if !buffering {
+		return nil
+	}
+	_, err := c.resend(stream)
+	return err
+}
+
+// resend replaces a stream whose 0-RTT data was rejected with a new one
+// on the completed connection, and writes the buffered data again. It
+// returns the new stream, or the current one if another call has
+// already replaced rejected.
+func (c *quicStreamConn) resend(rejected *quic.Stream) (*quic.Stream, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.stream != rejected {
+		return c.stream, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	// NextConnection returns the same connection, ready for new streams.
+	if _, err := c.conn.NextConnection(ctx); err != nil {
+		return nil, err
+	}
+	stream, err := c.conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open QUIC stream: %w", err)
+	}
+	_ = stream.SetReadDeadline(c.readDeadline)
+	_ = stream.SetWriteDeadline(c.writeDeadline)
+
+	if len(c.early) > 0 {
+		if _, err := stream.Write(c.early); err != nil {
+			return nil, err
+		}
+	}
+
+	c.stream = stream
+	c.buffering, c.early = false, nil
+	return stream, nil
+}
+
+func (c *quicStreamConn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline, c.writeDeadline = t, t
+	return c.stream.SetDeadline(t)
+}
+
+func (c *quicStreamConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	return c.stream.SetReadDeadline(t)
+}
+
+func (c *quicStreamConn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeDeadline = t
+	return c.stream.SetWriteDeadline(t)
+}
+
+func (c *quicStreamConn) LocalAddr() net.Addr  { return c.conn.LocalAddr() }
+func (c *quicStreamConn) RemoteAddr() net.Addr { return c.conn.RemoteAddr() }
+
+// ConnectionState returns the TLS state of the QUIC connection.
+func (c *quicStreamConn) ConnectionState() tls.ConnectionState {
+	return c.conn.ConnectionState().TLS
+}
+
+func (c *quicStreamConn) Close() error {
+	var err error
+	c.once.Do(func() {
+		c.mu.Lock()
+		stream := c.stream
+		c.mu.Unlock()
+
+		_ = stream.Close()
+		err = c.conn.CloseWithError(0, "")
+		if c.socket != nil {
+			_ = c.socket.Close()
+		}
+	})
+	return err
+}
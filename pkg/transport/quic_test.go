@@ -0,0 +1,310 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// localhostCertificate generates a certificate for localhost and a CA file
+// trusting it.
+func localhostCertificate(t *testing.T) (*tls.Certificate, string) {
+	t.Helper()
+
+	cert, err := GenerateCertificate([]string{"localhost"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, writeCAFile(t, cert.Leaf)
+}
+
+// quicEchoServer starts a QUIC listener that echoes every stream, with
+// its own session ticket keys, and returns its address.
+func quicEchoServer(t *testing.T, cert *tls.Certificate) string {
+	t.Helper()
+
+	ln, err := ListenQUIC("127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{*cert}}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return serveQUICEcho(t, ln)
+}
+
+// serveQUICEcho echoes every stream accepted by ln and returns its
+// address.
+func serveQUICEcho(t *testing.T, ln *QUICListener) string {
+	t.Helper()
+
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() { _ = conn.Close() }()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	return ln.Addr().String()
+}
+
+// dialIdleQUIC completes a QUIC handshake with address but opens no
+// stream.
+func dialIdleQUIC(t *testing.T, address string) *quic.Conn {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := quic.DialAddr(ctx, address, &tls.Config{
+		InsecureSkipVerify: true,
+		NextProtos:         []string{DefaultQUICALPN},
+	}, nil)
+	if err != nil {
+		t.Fatalf("DialAddr: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.CloseWithError(0, "") })
+	return conn
+}
+
+// dialQUICTest connects to localhost at address over QUIC.
+func dialQUICTest(t *testing.T, address, caFile string, cache tls.ClientSessionCache) *quicStreamConn {
+	t.Helper()
+
+	conn, err := DialQUIC(address, 5*time.Second, DialOptions{}, TLSOptions{
+		ServerName:   "localhost",
+		CAFile:       caFile,
+		SessionCache: cache,
+	})
+	if err != nil {
+		t.Fatalf("DialQUIC: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn.(*quicStreamConn)
+}
+
+// echo writes each chunk to conn and checks that all of it comes back.
+func echo(t *testing.T, conn net.Conn, chunks ...[]byte) {
+	t.Helper()
+
+	var want []byte
+	for _, chunk := range chunks {
+		if _, err := conn.Write(chunk); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		want = append(want, chunk...)
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	got := make([]byte, len(want))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatal("echoed data differs from the data sent")
+	}
+}
+
+// newSessionFile returns a session file path and a function opening a
+// cache on it, as each run of the program would.
+func newSessionFile(t *testing.T) (string, func() *FileSessionCache) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "sessions")
+	return path, func() *FileSessionCache {
+		cache, err := NewFileSessionCache(path, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cache
+	}
+}
+
+// storeSession connects once so that the server's session ticket is saved
+// in cache.
+func storeSession(t *testing.T, address, caFile string, cache *FileSessionCache) {
+	t.Helper()
+
+	conn := dialQUICTest(t, address, caFile, cache)
+	echo(t, conn, []byte("hello"))
+	if conn.handshakeDone != nil {
+		t.Fatal("first connection attempted 0-RTT without a session")
+	}
+
+	// The ticket arrives after the handshake.
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if sessions, err := cache.load(); err == nil && len(sessions) > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("no session ticket was saved")
+		}
+	}
+	_ = conn.Close()
+}
+
+func TestQUICStreamRelay(t *testing.T) {
+	cert, caFile := localhostCertificate(t)
+	address := quicEchoServer(t, cert)
+	conn := dialQUICTest(t, address, caFile, nil)
+
+	echo(t, conn, []byte("hello"), bytes.Repeat([]byte("x"), 256<<10))
+
+	state := conn.ConnectionState()
+	if state.NegotiatedProtocol != DefaultQUICALPN {
+		t.Errorf("ALPN = %q, want %q", state.NegotiatedProtocol, DefaultQUICALPN)
+	}
+}
+
+func TestQUIC0RTTAccepted(t *testing.T) {
+	cert, caFile := localhostCertificate(t)
+	address := quicEchoServer(t, cert)
+	_, openCache := newSessionFile(t)
+	storeSession(t, address, caFile, openCache())
+
+	conn := dialQUICTest(t, address, caFile, openCache())
+	if conn.handshakeDone == nil {
+		t.Fatal("0-RTT was not attempted with a stored session")
+	}
+	echo(t, conn, []byte("early data"))
+
+	<-conn.handshakeDone
+	if !conn.conn.ConnectionState().Used0RTT {
+		t.Error("server did not accept the 0-RTT data")
+	}
+}
+
+func TestQUIC0RTTRejected(t *testing.T) {
+	cert, caFile := localhostCertificate(t)
+	address := quicEchoServer(t, cert)
+	_, openCache := newSessionFile(t)
+	storeSession(t, address, caFile, openCache())
+
+	// A server with other ticket keys cannot resume the session.
+	other := quicEchoServer(t, cert)
+	conn := dialQUICTest(t, other, caFile, openCache())
+	if conn.handshakeDone == nil {
+		t.Fatal("0-RTT was not attempted with a stored session")
+	}
+
+	// The second write exceeds maxEarlyData, so it waits for the
+	// handshake and the rejected data is sent again first.
+	large := make([]byte, 2*maxEarlyData)
+	_, _ = rand.Read(large)
+	echo(t, conn, []byte("early data"), large)
+
+	if conn.conn.ConnectionState().Used0RTT {
+		t.Error("server accepted 0-RTT data with a foreign session ticket")
+	}
+}
+
+func TestQUICListenerIdleClientBlocksNoOther(t *testing.T) {
+	cert, caFile := localhostCertificate(t)
+	address := quicEchoServer(t, cert)
+
+	// A client that never opens a stream must not hold up the next one.
+	dialIdleQUIC(t, address)
+	conn := dialQUICTest(t, address, caFile, nil)
+	echo(t, conn, []byte("hello"))
+}
+
+func TestQUICListenerClosesIdleClient(t *testing.T) {
+	cert, _ := localhostCertificate(t)
+	ln, err := listenQUIC("127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{*cert}}, false, 200*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := serveQUICEcho(t, ln)
+
+	idle := dialIdleQUIC(t, address)
+	select {
+	case <-idle.Context().Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection without a stream was not closed")
+	}
+}
+
+func TestQUICListenerClose(t *testing.T) {
+	cert, _ := localhostCertificate(t)
+	ln, err := ListenQUIC("127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{*cert}}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	accepted := make(chan error, 1)
+	go func() {
+		_, err := ln.Accept()
+		accepted <- err
+	}()
+	_ = ln.Close()
+
+	select {
+	case err := <-accepted:
+		if err == nil {
+			t.Error("Accept succeeded on a closed listener")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Accept did not return after Close")
+	}
+}
+
+func TestFileSessionCacheRoundTrip(t *testing.T) {
+	cert, _ := localhostCertificate(t)
+	address := serveTLS(t, &tls.Config{Certificates: []tls.Certificate{*cert}}, func(conn *tls.Conn) {
+		_, _ = io.Copy(conn, conn)
+	})
+
+	path, openCache := newSessionFile(t)
+	dial := func(cache *FileSessionCache) tls.ConnectionState {
+		conn, err := tls.Dial("tcp", address, &tls.Config{
+			ServerName:         "localhost",
+			InsecureSkipVerify: true,
+			ClientSessionCache: cache,
+		})
+		if err != nil {
+			t.Fatalf("Dial: %v", err)
+		}
+		defer func() { _ = conn.Close() }()
+		// Reading the echo also processes the session ticket.
+		echo(t, conn, []byte("ping"))
+		return conn.ConnectionState()
+	}
+
+	if dial(openCache()).DidResume {
+		t.Fatal("first connection resumed a session")
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("session file: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("session file mode = %04o, want 0600", perm)
+	}
+
+	if !dial(openCache()).DidResume {
+		t.Error("session from the file was not resumed")
+	}
+}
+
+func TestFileSessionCacheRefusesSharedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions")
+	if err := os.WriteFile(path, []byte("{}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewFileSessionCache(path, false); err == nil {
+		t.Error("accepted a session file readable by other users")
+	}
+}
@@ -0,0 +1,165 @@
+package transport
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TokenBucket limits throughput to a rate in bytes per second, allowing
+// bursts of up to its size. It is safe for concurrent use, so a single
+// bucket can cap both directions of a connection combined.
+type TokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket creates a full bucket for rate bytes per second and
+// bursts of burst bytes. A zero burst allows a tenth of a second's worth,
+// but at least 4 KiB.
+func NewTokenBucket(rate, burst int64) *TokenBucket {
+	if burst <= 0 {
+		burst = max(rate/10, 4096)
+	}
+	return &TokenBucket{
+		rate:   float64(rate),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// Burst returns the bucket size in bytes.
+func (b *TokenBucket) Burst() int {
+	return int(b.burst)
+}
+
+// Wait takes n tokens, sleeping until the bucket has refilled enough to
+// pay for them.
+func (b *TokenBucket) Wait(n int) {
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens -= float64(n)
+	var wait time.Duration
+	if b.tokens < 0 {
+		wait = time.Duration(-b.tokens / b.rate * float64(time.Second))
+	}
+	b.mu.Unlock()
+
+	time.Sleep(wait)
+}
+
+// RateLimitedConn limits the throughput of a connection with token
+// buckets for reads and writes, which may be the same bucket. A nil
+// bucket leaves that direction unlimited.
+type RateLimitedConn struct {
+	net.Conn
+	read, write *TokenBucket
+
+	bytesRead    atomic.Int64
+	bytesWritten atomic.Int64
+}
+
+// NewRateLimitedConn wraps conn with the given read and write buckets.
+func NewRateLimitedConn(conn net.Conn, read, write *TokenBucket) *RateLimitedConn {
+	return &RateLimitedConn{Conn: conn, read: read, write: write}
+}
+
+// Read reads at most one burst and waits for the tokens to pay for it.
+func (c *RateLimitedConn) Read(p []byte) (int, error) {
+	if c.read != nil && len(p) > c.read.Burst() {
+		p = p[:c.read.Burst()]
+	}
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.bytesRead.Add(int64(n))
+		if c.read != nil {
+			c.read.Wait(n)
+		}
+	}
+	return n, err
+}
+
+// Write writes p in bursts, waiting for the tokens before each.
+func (c *RateLimitedConn) Write(p []byte) (int, error) {
+	if c.write == nil {
+		n, err := c.Conn.Write(p)
+		c.bytesWritten.Add(int64(n))
+		return n, err
+	}
+
+	written := 0
+	for written < len(p) {
+		chunk := p[written:]
+		if len(chunk) > c.write.Burst() {
+			chunk = chunk[:c.write.Burst()]
+		}
+		c.write.Wait(len(chunk))
+		n, err := c.Conn.Write(chunk)
+		written += n
+		c.bytesWritten.Add(int64(n))
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// Stats returns the number of bytes read and written so far.
+func (c *RateLimitedConn) Stats() (read, written int64) {
+	return c.bytesRead.Load(), c.bytesWritten.Load()
+}
+
+// ParseSize parses a byte count such as "512", "64K", "5M" or "1.5G".
+// The suffixes are powers of 1024, as with curl's --limit-rate, and may
+// be followed by "B".
+func ParseSize(value string) (int64, error) {
+	s := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(value)), "B")
+	multiplier := 1.0
+	if s != "" {
+		switch s[len(s)-1] {
+		case 'K':
+			multiplier = 1 << 10
+		case 'M':
+			multiplier = 1 << 20
+		case 'G':
+			multiplier = 1 << 30
+		}
+		if multiplier != 1 {
+			s = s[:len(s)-1]
+		}
+	}
+
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n*multiplier > 1<<62 {
+		return 0, fmt.Errorf("invalid size: %s", value)
+	}
+	// A fraction of a byte would truncate to zero, which means unlimited.
+	if n*multiplier < 1 {
+		return 0, fmt.Errorf("invalid size: %s (less than 1 byte)", value)
+	}
+	return int64(n * multiplier), nil
+}
+
+// FormatSize formats a byte count with a K, M or G suffix.
+func FormatSize(n float64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1fG", n/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1fM", n/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1fK", n/(1<<10))
+	default:
+		return fmt.Sprintf("%.0f", n)
+	}
+}
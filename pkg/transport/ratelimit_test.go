@@ -0,0 +1,74 @@
+package transport
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int64 // 0 for an error
+	}{
+		{"512", 512},
+		{"1", 1},
+		{"64K", 64 << 10},
+		{"64kb", 64 << 10},
+		{"5M", 5 << 20},
+		{"1.5G", 3 << 29},
+		{"0.5K", 512},
+		{"0.5", 0},
+		{"0.0001K", 0},
+		{"0", 0},
+		{"-1K", 0},
+		{"", 0},
+		{"K", 0},
+		{"10X", 0},
+		{"1e30G", 0},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseSize(tt.value)
+		switch {
+		case tt.want == 0 && err == nil:
+			t.Errorf("ParseSize(%q) = %d, want an error", tt.value, got)
+		case tt.want != 0 && (err != nil || got != tt.want):
+			t.Errorf("ParseSize(%q) = %d, %v, want %d", tt.value, got, err, tt.want)
+		}
+	}
+}
+
+func TestRateLimitedConnWriteDeadlinePerChunk(t *testing.T) {
+	client, server := net.Pipe()
+	defer func() { _ = client.Close() }()
+	received := make(chan []byte, 1)
+	go func() {
+		data, _ := io.ReadAll(server)
+		received <- data
+	}()
+
+	// Five 1000-byte chunks at 10000 bytes/s take about 0.4s, longer
+	// than the write timeout, but each chunk is written well within it.
+	timeouts := NewTimeoutDialer(client, 0, 250*time.Millisecond)
+	conn := NewRateLimitedConn(timeouts, nil, NewTokenBucket(10000, 1000))
+
+	data := bytes.Repeat([]byte("x"), 5000)
+	start := time.Now()
+	if n, err := conn.Write(data); err != nil || n != len(data) {
+		t.Fatalf("Write = %d, %v", n, err)
+	}
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Errorf("Write took %v, want the rate to be limited", elapsed)
+	}
+	_ = client.Close()
+
+	if got := <-received; !bytes.Equal(got, data) {
+		t.Errorf("received %d bytes, want %d", len(got), len(data))
+	}
+	if _, written := conn.Stats(); written != int64(len(data)) {
+		t.Errorf("Stats written = %d, want %d", written, len(data))
+	}
+}
@@ -0,0 +1,394 @@
+package transport
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// ResolverOptions controls how a Resolver reaches its DNS server.
+type ResolverOptions struct {
+	// Bind selects the local address and interface of direct queries.
+	// The source port is never bound.
+	Bind BindOptions
+
+	// Dial, if set, opens a stream connection to the DNS server (e.g.
+	// through a proxy). Plain DNS is then sent over TCP.
+	Dial func(network, address string) (net.Conn, error)
+
+	// RootCAs verifies DoT and DoH servers; the system roots if nil.
+	RootCAs *x509.CertPool
+
+	Timeout time.Duration
+	Verbose bool // report every query and its duration
+}
+
+// Resolver sends DNS queries to a configurable server using plain DNS,
+// DNS-over-TLS (tls://host[:port]) or DNS-over-HTTPS (https://host/path).
+type Resolver struct {
+	scheme string // "dns", "tls" or "https"
+	server string // host:port, or the DoH URL
+	host   string // server name verified for DoT and DoH
+	opts   ResolverOptions
+	client *http.Client
+}
+
+// NewResolver creates a resolver for server. If server is empty, the first
+// nameserver from /etc/resolv.conf is used.
+func NewResolver(server string, opts ResolverOptions) (*Resolver, error) {
+	if opts.Timeout == 0 {
+		opts.Timeout = 30 * time.Second
+	}
+	opts.Bind.Port = 0
+	r := &Resolver{opts: opts}
+
+	switch {
+	case server == "":
+		ns, err := systemNameserver()
+		if err != nil {
+			return nil, err
+		}
+		r.scheme, r.server = "dns", ns
+	case strings.HasPrefix(server, "tls://"):
+		r.scheme = "tls"
+		r.server = withDefaultPort(strings.TrimPrefix(server, "tls://"), "853")
+		r.host, _, _ = net.SplitHostPort(r.server)
+	case strings.HasPrefix(server, "https://"):
+		u, err := url.Parse(server)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid DNS-over-HTTPS URL: %s", server)
+		}
+		if u.Path == "" {
+			u.Path = "/dns-query"
+		}
+		r.scheme, r.server, r.host = "https", u.String(), u.Hostname()
+		r.client = r.httpClient()
+	case strings.Contains(server, "://"):
+		return nil, fmt.Errorf("unsupported DNS server scheme: %s (expected host:port, tls:// or https://)", server)
+	default:
+		r.scheme = "dns"
+		r.server = withDefaultPort(server, "53")
+	}
+
+	if r.host == "" && r.scheme != "dns" {
+		return nil, fmt.Errorf("missing DNS server host: %s", server)
+	}
+	return r, nil
+}
+
+// withDefaultPort appends port to hostport if it has none.
+func withDefaultPort(hostport, port string) string {
+	if _, _, err := net.SplitHostPort(hostport); err == nil {
+		return hostport
+	}
+	return net.JoinHostPort(strings.Trim(hostport, "[]"), port)
+}
+
+// String describes the server for verbose output.
+func (r *Resolver) String() string {
+	switch r.scheme {
+	case "tls":
+		return "tls://" + r.server
+	default:
+		return r.server
+	}
+}
+
+// NetResolver returns a net.Resolver sending all queries to the server.
+func (r *Resolver) NetResolver() *net.Resolver {
+	return &net.Resolver{PreferGo: true, Dial: r.dialContext}
+}
+
+// dialContext implements net.Resolver.Dial. The address chosen by the Go
+// resolver is ignored in favour of the configured server. Connections that
+// are not net.PacketConns make the resolver use TCP framing.
+func (r *Resolver) dialContext(ctx context.Context, network, _ string) (net.Conn, error) {
+	var conn net.Conn
+	var err error
+
+	switch r.scheme {
+	case "https":
+		conn = &dohConn{ctx: ctx, r: r}
+	case "tls":
+		if conn, err = r.dialStream(ctx); err != nil {
+			return nil, err
+		}
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: r.host, RootCAs: r.opts.RootCAs})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("DNS-over-TLS handshake with %s failed: %w", r.server, err)
+		}
+		conn = tlsConn
+	default:
+		if r.opts.Dial != nil {
+			conn, err = r.dialStream(ctx)
+		} else {
+			var dialer *net.Dialer
+			if dialer, err = r.opts.Bind.Dialer(network, r.opts.Timeout); err == nil {
+				conn, err = dialer.DialContext(ctx, network, r.server)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !r.opts.Verbose {
+		return conn, nil
+	}
+	logged := &queryLogConn{Conn: conn, server: r.String()}
+	if pc, ok := conn.(net.PacketConn); ok {
+		return &queryLogPacketConn{queryLogConn: logged, pc: pc}, nil
+	}
+	logged.stream = true
+	return logged, nil
+}
+
+// dialStream opens a TCP connection to the server, through opts.Dial if set.
+func (r *Resolver) dialStream(ctx context.Context) (net.Conn, error) {
+	if r.opts.Dial != nil {
+		return r.opts.Dial("tcp", r.server)
+	}
+	dialer, err := r.opts.Bind.Dialer("tcp", r.opts.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	return dialer.DialContext(ctx, "tcp", r.server)
+}
+
+// httpClient returns the client for DNS-over-HTTPS requests.
+func (r *Resolver) httpClient() *http.Client {
+	transport := &http.Transport{
+		Proxy:             nil,
+		ForceAttemptHTTP2: true,
+		TLSClientConfig:   &tls.Config{RootCAs: r.opts.RootCAs},
+	}
+	transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		if r.opts.Dial != nil {
+			return r.opts.Dial(network, address)
+		}
+		dialer, err := r.opts.Bind.Dialer(network, r.opts.Timeout)
+		if err != nil {
+			return nil, err
+		}
+		return dialer.DialContext(ctx, network, address)
+	}
+	return &http.Client{Transport: transport, Timeout: r.opts.Timeout}
+}
+
+// Exchange sends a single query and returns the answer, retrying over TCP
+// if a UDP answer is truncated.
+func (r *Resolver) Exchange(ctx context.Context, msg dnsmessage.Message) (*dnsmessage.Message, error) {
+	query, err := msg.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := r.exchange(ctx, "udp", query)
+	if err == nil && resp.Header.Truncated {
+		resp, err = r.exchange(ctx, "tcp", query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if resp.Header.ID != msg.Header.ID {
+		return nil, errors.New("mismatched response ID")
+	}
+	return resp, nil
+}
+
+func (r *Resolver) exchange(ctx context.Context, network string, query []byte) (*dnsmessage.Message, error) {
+	conn, err := r.dialContext(ctx, network, "")
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = conn.Close() }()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	var data []byte
+	if _, ok := conn.(net.PacketConn); ok {
+		if _, err := conn.Write(query); err != nil {
+			return nil, err
+		}
+		buf := make([]byte, 65535)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		data = buf[:n]
+	} else {
+		framed := binary.BigEndian.AppendUint16(nil, uint16(len(query)))
+		if _, err := conn.Write(append(framed, query...)); err != nil {
+			return nil, err
+		}
+		var length uint16
+		if err := binary.Read(conn, binary.BigEndian, &length); err != nil {
+			return nil, err
+		}
+		data = make([]byte, length)
+		if _, err := io.ReadFull(conn, data); err != nil {
+			return nil, err
+		}
+	}
+
+	var resp dnsmessage.Message
+	if err := resp.Unpack(data); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// dohConn adapts DNS-over-HTTPS to the TCP framing used by the Go
+// resolver: each length-prefixed query written is POSTed to the server and
+// the answer is made available to Read with the same framing.
+type dohConn struct {
+	ctx      context.Context
+	r        *Resolver
+	resp     bytes.Buffer
+	deadline time.Time
+}
+
+func (c *dohConn) Write(b []byte) (int, error) {
+	if len(b) < 2 || int(binary.BigEndian.Uint16(b)) != len(b)-2 {
+		return 0, errors.New("DNS-over-HTTPS: expected a single framed query per write")
+	}
+
+	ctx := c.ctx
+	if !c.deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, c.deadline)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.r.server, bytes.NewReader(b[2:]))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+
+	resp, err := c.r.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("DNS-over-HTTPS request to %s failed: %w", c.r.server, err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("DNS-over-HTTPS server %s returned %s", c.r.server, resp.Status)
+	}
+	answer, err := io.ReadAll(io.LimitReader(resp.Body, 65535))
+	if err != nil {
+		return 0, err
+	}
+
+	c.resp.Write(binary.BigEndian.AppendUint16(nil, uint16(len(answer))))
+	c.resp.Write(answer)
+	return len(b), nil
+}
+
+func (c *dohConn) Read(b []byte) (int, error) {
+	if c.resp.Len() == 0 {
+		return 0, io.EOF
+	}
+	return c.resp.Read(b)
+}
+
+func (c *dohConn) Close() error                       { return nil }
+func (c *dohConn) LocalAddr() net.Addr                { return dohAddr("local") }
+func (c *dohConn) RemoteAddr() net.Addr               { return dohAddr(c.r.server) }
+func (c *dohConn) SetDeadline(t time.Time) error      { c.deadline = t; return nil }
+func (c *dohConn) SetReadDeadline(time.Time) error    { return nil }
+func (c *dohConn) SetWriteDeadline(t time.Time) error { c.deadline = t; return nil }
+
+type dohAddr string
+
+func (a dohAddr) Network() string { return "https" }
+func (a dohAddr) String() string  { return string(a) }
+
+// queryLogConn reports the DNS query sent over it, and how long the
+// exchange took, when closed.
+type queryLogConn struct {
+	net.Conn
+	server   string
+	stream   bool // TCP framing
+	question string
+	start    time.Time
+}
+
+func (c *queryLogConn) Write(b []byte) (int, error) {
+	if c.question == "" {
+		msg := b
+		if c.stream && len(msg) >= 2 {
+			msg = msg[2:]
+		}
+		c.question = describeQuestion(msg)
+		c.start = time.Now()
+	}
+	return c.Conn.Write(b)
+}
+
+func (c *queryLogConn) Close() error {
+	if c.question != "" {
+		fmt.Fprintf(os.Stderr, "DNS %s via %s: %v\n", c.question, c.server, time.Since(c.start).Round(time.Microsecond))
+	}
+	return c.Conn.Close()
+}
+
+// queryLogPacketConn is a queryLogConn over UDP; it must remain a
+// net.PacketConn for the Go resolver to use UDP framing.
+type queryLogPacketConn struct {
+	*queryLogConn
+	pc net.PacketConn
+}
+
+func (c *queryLogPacketConn) ReadFrom(b []byte) (int, net.Addr, error) { return c.pc.ReadFrom(b) }
+func (c *queryLogPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	return c.pc.WriteTo(b, addr)
+}
+
+// describeQuestion returns "TYPE name" for the first question of a query.
+func describeQuestion(msg []byte) string {
+	var p dnsmessage.Parser
+	if _, err := p.Start(msg); err != nil {
+		return "query"
+	}
+	q, err := p.Question()
+	if err != nil {
+		return "query"
+	}
+	return strings.TrimPrefix(q.Type.String(), "Type") + " " + q.Name.String()
+}
+
+// systemNameserver returns the first nameserver from /etc/resolv.conf.
+func systemNameserver() (string, error) {
+	f, err := os.Open("/etc/resolv.conf")
+	if err != nil {
+		return "", fmt.Errorf("no DNS server configured: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			return net.JoinHostPort(fields[1], "53"), nil
+		}
+	}
+	return "", errors.New("no nameserver found in /etc/resolv.conf")
+}
@@ -0,0 +1,280 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// testAddress is the A record every test DNS server answers with.
+var testAddress = [4]byte{192, 0, 2, 10}
+
+// answerDNS answers an A query with testAddress and any other query with
+// no records.
+func answerDNS(query []byte) []byte {
+	var msg dnsmessage.Message
+	if err := msg.Unpack(query); err != nil || len(msg.Questions) == 0 {
+		return nil
+	}
+	q := msg.Questions[0]
+
+	resp := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: msg.Header.ID, Response: true, RecursionDesired: true, RecursionAvailable: true},
+		Questions: []dnsmessage.Question{q},
+	}
+	if q.Type == dnsmessage.TypeA {
+		resp.Answers = append(resp.Answers, dnsmessage.Resource{
+			Header: dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 60},
+			Body:   &dnsmessage.AResource{A: testAddress},
+		})
+	}
+	packed, err := resp.Pack()
+	if err != nil {
+		return nil
+	}
+	return packed
+}
+
+// serveDNSStream answers length-prefixed queries on conn until it is closed.
+func serveDNSStream(conn net.Conn) {
+	for {
+		var length uint16
+		if err := binary.Read(conn, binary.BigEndian, &length); err != nil {
+			return
+		}
+		query := make([]byte, length)
+		if _, err := io.ReadFull(conn, query); err != nil {
+			return
+		}
+		answer := answerDNS(query)
+		framed := binary.BigEndian.AppendUint16(nil, uint16(len(answer)))
+		if _, err := conn.Write(append(framed, answer...)); err != nil {
+			return
+		}
+	}
+}
+
+// serveDNSUDP starts a plain DNS server over UDP and returns its address.
+func serveDNSUDP(t *testing.T) string {
+	t.Helper()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = pc.Close() })
+
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, _ = pc.WriteTo(answerDNS(buf[:n]), addr)
+		}
+	}()
+
+	return pc.LocalAddr().String()
+}
+
+// serveDNSTCP starts a plain DNS server over TCP and returns its address.
+func serveDNSTCP(t *testing.T) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() { _ = conn.Close() }()
+				serveDNSStream(conn)
+			}()
+		}
+	}()
+
+	return ln.Addr().String()
+}
+
+// lookup resolves a name through r and checks that testAddress comes back.
+func lookup(t *testing.T, r *Resolver) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	addrs, err := r.NetResolver().LookupHost(ctx, "example.test.")
+	if err != nil {
+		t.Fatalf("LookupHost via %s: %v", r, err)
+	}
+	want := net.IP(testAddress[:]).String()
+	if !slices.Contains(addrs, want) {
+		t.Errorf("LookupHost via %s = %v, want %s", r, addrs, want)
+	}
+}
+
+func TestResolverPlain(t *testing.T) {
+	r, err := NewResolver(serveDNSUDP(t), ResolverOptions{Timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lookup(t, r)
+}
+
+func TestResolverPlainThroughDial(t *testing.T) {
+	// A stream dialer, as used through a proxy, makes plain DNS use TCP.
+	var mu sync.Mutex
+	var dialed []string
+	r, err := NewResolver(serveDNSTCP(t), ResolverOptions{
+		Dial: func(network, address string) (net.Conn, error) {
+			mu.Lock()
+			dialed = append(dialed, network)
+			mu.Unlock()
+			return net.Dial(network, address)
+		},
+		Timeout: 5 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lookup(t, r)
+
+	if len(dialed) == 0 || slices.ContainsFunc(dialed, func(n string) bool { return n != "tcp" }) {
+		t.Errorf("dialed %v, want only tcp", dialed)
+	}
+}
+
+func TestResolverDoT(t *testing.T) {
+	cert, err := GenerateCertificate([]string{"localhost"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := serveTLS(t, &tls.Config{Certificates: []tls.Certificate{*cert}}, func(conn *tls.Conn) {
+		serveDNSStream(conn)
+	})
+	roots := x509.NewCertPool()
+	roots.AddCert(cert.Leaf)
+
+	r, err := NewResolver("tls://"+address, ResolverOptions{RootCAs: roots, Timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lookup(t, r)
+
+	// Without the test CA the server must not be trusted.
+	untrusted, err := NewResolver("tls://"+address, ResolverOptions{Timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := untrusted.NetResolver().LookupHost(context.Background(), "example.test."); err == nil {
+		t.Error("lookup succeeded against an untrusted DoT server")
+	}
+}
+
+func TestResolverDoH(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/dns-query" ||
+			r.Header.Get("Content-Type") != "application/dns-message" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		query, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/dns-message")
+		_, _ = w.Write(answerDNS(query))
+	}))
+	defer server.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+
+	r, err := NewResolver(server.URL, ResolverOptions{RootCAs: roots, Timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lookup(t, r)
+
+	// A server error is reported with its status.
+	failing, err := NewResolver(server.URL+"/other", ResolverOptions{RootCAs: roots, Timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := failing.dialContext(context.Background(), "tcp", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	query, err := (&dnsmessage.Message{Questions: []dnsmessage.Question{{
+		Name:  dnsmessage.MustNewName("example.test."),
+		Type:  dnsmessage.TypeA,
+		Class: dnsmessage.ClassINET,
+	}}}).Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	framed := binary.BigEndian.AppendUint16(nil, uint16(len(query)))
+	if _, err := conn.Write(append(framed, query...)); err == nil || !strings.Contains(err.Error(), "400") {
+		t.Errorf("Write error = %v, want the 400 status", err)
+	}
+}
+
+func TestDoHConnFraming(t *testing.T) {
+	conn := &dohConn{ctx: context.Background(), r: &Resolver{server: "https://127.0.0.1:1/dns-query"}}
+
+	for _, b := range [][]byte{{0x00}, {0x00, 0x05, 0x01}, {0x00, 0x01, 0x01, 0x02}} {
+		if _, err := conn.Write(b); err == nil {
+			t.Errorf("Write(%x) accepted a badly framed query", b)
+		}
+	}
+	if _, err := conn.Read(make([]byte, 16)); err != io.EOF {
+		t.Errorf("Read without an answer = %v, want EOF", err)
+	}
+}
+
+func TestNewResolverSchemes(t *testing.T) {
+	tests := []struct {
+		server string
+		want   string // String() of the resolver, or "" for an error
+	}{
+		{"192.0.2.53", "192.0.2.53:53"},
+		{"[2001:db8::53]:5353", "[2001:db8::53]:5353"},
+		{"tls://dns.example", "tls://dns.example:853"},
+		{"tls://2001:db8::53", "tls://[2001:db8::53]:853"},
+		{"https://dns.example", "https://dns.example/dns-query"},
+		{"https://dns.example/custom", "https://dns.example/custom"},
+		{"quic://dns.example", ""},
+		{"https://", ""},
+	}
+
+	for _, tt := range tests {
+		r, err := NewResolver(tt.server, ResolverOptions{})
+		switch {
+		case tt.want == "" && err == nil:
+			t.Errorf("%s: accepted, want an error", tt.server)
+		case tt.want != "" && err != nil:
+			t.Errorf("%s: %v", tt.server, err)
+		case tt.want != "" && r.String() != tt.want:
+			t.Errorf("%s: resolver = %s, want %s", tt.server, r, tt.want)
+		}
+	}
+}
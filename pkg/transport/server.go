@@ -0,0 +1,241 @@
+package transport
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// ServerTLSOptions holds the server-side TLS settings for listen mode.
+type ServerTLSOptions struct {
+	// Server certificate, as for the client certificate in TLSOptions.
+	// Without CertFile an ephemeral self-signed certificate is generated.
+	CertFile    string
+	KeyFile     string
+	KeyPassword string
+
+	// ClientCAFile, if set, requires clients to present a certificate
+	// issued by one of these CAs (PEM file or directory); with
+	// ClientCAAppend the system roots are trusted as well.
+	ClientCAFile   string
+	ClientCAAppend bool
+
+	// ALPN protocols to accept, in order of preference.
+	ALPN []string
+
+	// Protocol constraints; zero values use the crypto/tls defaults.
+	MinVersion   uint16
+	MaxVersion   uint16
+	CipherSuites []uint16
+	Curves       []tls.CurveID
+
+	KeyLogFile string
+	Verbose    bool
+}
+
+// NewServerTLSConfig builds the TLS configuration for accepting
+// connections. A certificate loaded from files is reloaded when they
+// change; a generated one is announced on stderr with its fingerprint
+// and public key pin, so that clients can verify it.
+func NewServerTLSConfig(opts ServerTLSOptions) (*tls.Config, error) {
+	if opts.MinVersion != 0 && opts.MaxVersion != 0 && opts.MinVersion > opts.MaxVersion {
+		return nil, fmt.Errorf("minimum TLS version is above the maximum")
+	}
+
+	config := &tls.Config{
+		NextProtos:       opts.ALPN,
+		MinVersion:       opts.MinVersion,
+		MaxVersion:       opts.MaxVersion,
+		CipherSuites:     opts.CipherSuites,
+		CurvePreferences: opts.Curves,
+	}
+
+	switch {
+	case opts.CertFile != "":
+		loader, err := NewCertificateLoader(opts.CertFile, opts.KeyFile, opts.KeyPassword)
+		if err != nil {
+			return nil, err
+		}
+		config.GetCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return loader.Certificate()
+		}
+	case opts.KeyFile != "":
+		return nil, fmt.Errorf("a private key requires a certificate")
+	default:
+		cert, err := GenerateCertificate(selfSignedNames(), 24*time.Hour)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(os.Stderr, "Generated a self-signed certificate for %s, valid until %s\n",
+			strings.Join(cert.Leaf.DNSNames, ", "), formatTime(cert.Leaf.NotAfter))
+		fmt.Fprintf(os.Stderr, "SHA-256 fingerprint: %s\n", CertificateFingerprint(cert.Leaf))
+		fmt.Fprintf(os.Stderr, "Public key pin: %s\n", FormatPin(SPKIHash(cert.Leaf)))
+		config.Certificates = []tls.Certificate{*cert}
+	}
+
+	if opts.ClientCAFile != "" {
+		pool, err := LoadCertPool(opts.ClientCAFile, opts.ClientCAAppend)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+		if opts.Verbose {
+			fmt.Fprintf(os.Stderr, "Requiring client certificates issued by %s\n",
+				describeRoots(opts.ClientCAFile, opts.ClientCAAppend))
+		}
+	}
+
+	if opts.KeyLogFile != "" {
+		keyLog, err := OpenKeyLog(opts.KeyLogFile)
+		if err != nil {
+			return nil, err
+		}
+		config.KeyLogWriter = keyLog
+		if opts.Verbose {
+			fmt.Fprintf(os.Stderr, "WARNING: TLS session secrets are being logged to %s.\n", opts.KeyLogFile)
+			fmt.Fprintln(os.Stderr, "WARNING: anyone with this file can decrypt the captured traffic.")
+		}
+	}
+
+	return config, nil
+}
+
+// ServerHandshake completes the TLS handshake of a connection accepted
+// from a tls.NewListener, allowing 30 seconds for it. It is meant for the
+// goroutine serving the connection, so that a client that stalls the
+// handshake holds up no other. Other connections are left as they are.
+func ServerHandshake(conn net.Conn) error {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return fmt.Errorf("TLS handshake with %s failed: %w", describePeer(tlsConn.NetConn()), err)
+	}
+	return nil
+}
+
+// describePeer names the peer of an accepted connection, which for a Unix
+// socket has no address.
+func describePeer(conn net.Conn) string {
+	if addr := conn.RemoteAddr(); addr != nil && addr.String() != "" {
+		return addr.String()
+	}
+	if peer := PeerCredentials(conn); peer != "" {
+		return peer
+	}
+	return "unknown peer"
+}
+
+// selfSignedNames returns the names a generated certificate is valid
+// for: localhost and the host name.
+func selfSignedNames() []string {
+	names := []string{"localhost"}
+	if host, err := os.Hostname(); err == nil && host != "" && host != "localhost" {
+		names = append(names, host)
+	}
+	return names
+}
+
+// GenerateCertificate creates a self-signed ECDSA P-256 certificate for
+// the given names, valid for the given duration. It also covers the
+// loopback addresses.
+func GenerateCertificate(names []string, validity time.Duration) (*tls.Certificate, error) {
+	now := time.Now()
+	template := &x509.Certificate{
+		Subject:               pkix.Name{CommonName: names[0]},
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              now.Add(validity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	for _, name := range names {
+		if ip := net.ParseIP(name); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, name)
+		}
+	}
+
+	return issueCertificate(template, nil, nil)
+}
+
+// issueCertificate creates a certificate from template with a new P-256
+// key and a random serial number. It is signed by parent, or self-signed
+// if parent is nil.
+func issueCertificate(template, parent *x509.Certificate, parentKey crypto.Signer) (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+	template.SerialNumber = serial
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate: %w", err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}
+
+// CertificateFingerprint returns the SHA-256 fingerprint of a certificate
+// as colon-separated hex.
+func CertificateFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return formatFingerprint(sum[:])
+}
+
+// DescribeServerState summarizes an accepted TLS connection in one line.
+func DescribeServerState(state tls.ConnectionState) string {
+	parts := []string{tls.VersionName(state.Version), tls.CipherSuiteName(state.CipherSuite)}
+	if state.NegotiatedProtocol != "" {
+		parts = append(parts, "ALPN "+state.NegotiatedProtocol)
+	} else {
+		parts = append(parts, "no ALPN")
+	}
+	if state.ServerName != "" {
+		parts = append(parts, "SNI "+state.ServerName)
+	} else {
+		parts = append(parts, "no SNI")
+	}
+	if len(state.PeerCertificates) > 0 {
+		parts = append(parts, "client certificate "+state.PeerCertificates[0].Subject.String())
+	} else {
+		parts = append(parts, "no client certificate")
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,152 @@
+package transport
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// FileSessionCache is a tls.ClientSessionCache kept in a file, so that
+// later runs can resume their sessions and, over QUIC, send 0-RTT data.
+// The file holds session secrets and is written readable by the owner
+// only.
+type FileSessionCache struct {
+	path    string
+	verbose bool
+	mu      sync.Mutex
+}
+
+// storedSession is a session in the cache file.
+type storedSession struct {
+	Ticket []byte `json:"ticket"`
+	State  []byte `json:"state"`
+}
+
+// NewFileSessionCache creates a cache backed by path. The file need not
+// exist yet, but an existing one must not be accessible by other users.
+func NewFileSessionCache(path string, verbose bool) (*FileSessionCache, error) {
+	info, err := os.Stat(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to open session file: %w", err)
+	}
+	if err == nil && info.Mode().Perm()&0o077 != 0 {
+		return nil, fmt.Errorf("session file %s is accessible by other users (mode %04o); restrict it to 0600",
+			path, info.Mode().Perm())
+	}
+	return &FileSessionCache{path: path, verbose: verbose}, nil
+}
+
+// Get returns the stored session for key, if any.
+func (c *FileSessionCache) Get(key string) (*tls.ClientSessionState, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	sessions, err := c.load()
+	if err != nil {
+		if c.verbose {
+			fmt.Fprintf(os.Stderr, "Ignoring session file: %v\n", err)
+		}
+		return nil, false
+	}
+	stored, ok := sessions[key]
+	if !ok {
+		return nil, false
+	}
+
+	state, err := tls.ParseSessionState(stored.State)
+	if err != nil {
+		if c.verbose {
+			fmt.Fprintf(os.Stderr, "Ignoring stored session for %s: %v\n", key, err)
+		}
+		return nil, false
+	}
+	session, err := tls.NewResumptionState(stored.Ticket, state)
+	if err != nil {
+		return nil, false
+	}
+
+	if c.verbose {
+		fmt.Fprintf(os.Stderr, "Resuming TLS session for %s from %s\n", key, c.path)
+	}
+	return session, true
+}
+
+// Put stores the session for key, or removes it if session is nil.
+func (c *FileSessionCache) Put(key string, session *tls.ClientSessionState) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	sessions, err := c.load()
+	if err != nil {
+		sessions = make(map[string]storedSession)
+	}
+
+	if session == nil {
+		delete(sessions, key)
+	} else {
+		ticket, state, err := session.ResumptionState()
+		if err != nil || state == nil {
+			return
+		}
+		data, err := state.Bytes()
+		if err != nil {
+			return
+		}
+		sessions[key] = storedSession{Ticket: ticket, State: data}
+	}
+
+	if err := c.save(sessions); err != nil {
+		if c.verbose {
+			fmt.Fprintf(os.Stderr, "Failed to save TLS session: %v\n", err)
+		}
+		return
+	}
+	if c.verbose && session != nil {
+		fmt.Fprintf(os.Stderr, "Saved TLS session ticket for %s to %s\n", key, c.path)
+	}
+}
+
+// load reads the cache file. A missing file is an empty cache.
+func (c *FileSessionCache) load() (map[string]storedSession, error) {
+	sessions := make(map[string]storedSession)
+	data, err := os.ReadFile(c.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return sessions, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &sessions); err != nil {
+		return nil, fmt.Errorf("%s: %w", c.path, err)
+	}
+	return sessions, nil
+}
+
+// save replaces the cache file, so that concurrent runs never read a
+// partial one.
+func (c *FileSessionCache) save(sessions map[string]storedSession) error {
+	data, err := json.Marshal(sessions)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(c.path), ".session-*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(f.Name()) }()
+
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), c.path)
+}
@@ -0,0 +1,125 @@
+package transport
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SocketOptions tunes outgoing TCP sockets. Zero values leave the system
+// defaults in place.
+type SocketOptions struct {
+	// Keepalive probes: idle time before the first probe, interval
+	// between probes and the number of unanswered probes before the
+	// connection is dropped. Setting any of them enables keepalive.
+	KeepAliveIdle     time.Duration
+	KeepAliveInterval time.Duration
+	KeepAliveCount    int
+
+	// Nagle leaves TCP_NODELAY off. Go enables TCP_NODELAY by default.
+	Nagle bool
+
+	SendBuffer    int // SO_SNDBUF in bytes
+	ReceiveBuffer int // SO_RCVBUF in bytes
+	TOS           int // IP_TOS / IPV6_TCLASS
+	Mark          int // SO_MARK (fwmark)
+
+	// UserTimeout is TCP_USER_TIMEOUT: how long sent data may remain
+	// unacknowledged before the connection is dropped.
+	UserTimeout time.Duration
+
+	// FastOpen sends the first data written in the SYN (TCP Fast Open),
+	// once the server has handed out a cookie.
+	FastOpen bool
+}
+
+// IsZero reports whether no socket option is set.
+func (s SocketOptions) IsZero() bool {
+	return s == SocketOptions{}
+}
+
+// needsControl reports whether any option must be set before connecting.
+func (s SocketOptions) needsControl() bool {
+	s.Nagle = false
+	return !s.IsZero()
+}
+
+// keepAlive reports whether any keepalive option is set.
+func (s SocketOptions) keepAlive() bool {
+	return s.KeepAliveIdle > 0 || s.KeepAliveInterval > 0 || s.KeepAliveCount > 0
+}
+
+// seconds rounds d up to whole seconds, as keepalive options require.
+func seconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
+
+// sockoptError reports a socket option the kernel rejected.
+type sockoptError struct {
+	name string
+	err  error
+}
+
+func (e *sockoptError) Error() string {
+	return "failed to set " + e.name + ": " + e.err.Error()
+}
+
+func (e *sockoptError) Unwrap() error { return e.err }
+
+// TCPFeatures reports whether conn uses Multipath TCP and whether data
+// was carried in its SYN (TCP Fast Open). It is only accurate once the
+// handshake has completed. conn may be wrapped, e.g. in TLS.
+func TCPFeatures(conn net.Conn) (multipath, fastOpen bool) {
+	tcpConn, ok := underlyingConn(conn).(*net.TCPConn)
+	if !ok {
+		return false, false
+	}
+	multipath, _ = tcpConn.MultipathTCP()
+	return multipath, fastOpenUsed(tcpConn)
+}
+
+// DescribeTCPFeatures describes the MPTCP and TFO use of conn for verbose
+// output, mentioning only the features that were requested.
+func DescribeTCPFeatures(conn net.Conn, multipath, fastOpen bool) string {
+	usedMultipath, usedFastOpen := TCPFeatures(conn)
+	var parts []string
+	if multipath {
+		parts = append(parts, "MPTCP "+inUse(usedMultipath))
+	}
+	if fastOpen {
+		parts = append(parts, "TCP Fast Open "+inUse(usedFastOpen))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func inUse(used bool) string {
+	if used {
+		return "in use"
+	}
+	return "not used"
+}
+
+// dscpNames maps DSCP class names (RFC 4594) to code points.
+var dscpNames = map[string]int{
+	"be": 0, "df": 0, "le": 1, "ef": 46, "va": 44,
+	"cs0": 0, "cs1": 8, "cs2": 16, "cs3": 24, "cs4": 32, "cs5": 40, "cs6": 48, "cs7": 56,
+	"af11": 10, "af12": 12, "af13": 14,
+	"af21": 18, "af22": 20, "af23": 22,
+	"af31": 26, "af32": 28, "af33": 30,
+	"af41": 34, "af42": 36, "af43": 38,
+}
+
+// ParseTOS parses a TOS byte (decimal or 0x hex) or a DSCP class name such
+// as "ef" or "af41", returning the value for IP_TOS.
+func ParseTOS(value string) (int, error) {
+	if dscp, ok := dscpNames[strings.ToLower(value)]; ok {
+		return dscp << 2, nil
+	}
+	tos, err := strconv.ParseInt(value, 0, 0)
+	if err != nil || tos < 0 || tos > 255 {
+		return 0, fmt.Errorf("invalid TOS: %s (expected 0-255 or a DSCP name like ef, af41, cs1)", value)
+	}
+	return int(tos), nil
+}
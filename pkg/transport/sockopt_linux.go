@@ -0,0 +1,137 @@
+package transport
+
+import (
+	"net"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// tcpUserTimeout is TCP_USER_TIMEOUT, which package syscall lacks.
+const tcpUserTimeout = 0x12
+
+// control applies the options to a socket before it connects.
+func (s SocketOptions) control(network string, c syscall.RawConn) error {
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		sockErr = s.apply(int(fd), network)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
+
+func (s SocketOptions) apply(fd int, network string) error {
+	set := func(level, opt, value int, name string) error {
+		if err := syscall.SetsockoptInt(fd, level, opt, value); err != nil {
+			return &sockoptError{name: name, err: err}
+		}
+		return nil
+	}
+
+	if s.SendBuffer > 0 {
+		if err := set(syscall.SOL_SOCKET, syscall.SO_SNDBUF, s.SendBuffer, "SO_SNDBUF"); err != nil {
+			return err
+		}
+	}
+	if s.ReceiveBuffer > 0 {
+		if err := set(syscall.SOL_SOCKET, syscall.SO_RCVBUF, s.ReceiveBuffer, "SO_RCVBUF"); err != nil {
+			return err
+		}
+	}
+	if s.Mark > 0 {
+		if err := set(syscall.SOL_SOCKET, syscall.SO_MARK, s.Mark, "SO_MARK"); err != nil {
+			return err
+		}
+	}
+	if s.TOS > 0 {
+		var err error
+		if strings.HasSuffix(network, "6") {
+			err = set(syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, s.TOS, "IPV6_TCLASS")
+		} else {
+			err = set(syscall.IPPROTO_IP, syscall.IP_TOS, s.TOS, "IP_TOS")
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if !strings.HasPrefix(network, "tcp") {
+		return nil
+	}
+
+	if s.FastOpen {
+		if err := set(syscall.IPPROTO_TCP, tcpFastOpenConnect, 1, "TCP_FASTOPEN_CONNECT"); err != nil {
+			return err
+		}
+	}
+
+	if s.keepAlive() {
+		if err := set(syscall.SOL_SOCKET, syscall.SO_KEEPALIVE, 1, "SO_KEEPALIVE"); err != nil {
+			return err
+		}
+		if s.KeepAliveIdle > 0 {
+			if err := set(syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE, seconds(s.KeepAliveIdle), "TCP_KEEPIDLE"); err != nil {
+				return err
+			}
+		}
+		if s.KeepAliveInterval > 0 {
+			if err := set(syscall.IPPROTO_TCP, syscall.TCP_KEEPINTVL, seconds(s.KeepAliveInterval), "TCP_KEEPINTVL"); err != nil {
+				return err
+			}
+		}
+		if s.KeepAliveCount > 0 {
+			if err := set(syscall.IPPROTO_TCP, syscall.TCP_KEEPCNT, s.KeepAliveCount, "TCP_KEEPCNT"); err != nil {
+				return err
+			}
+		}
+	}
+	if s.UserTimeout > 0 {
+		if err := set(syscall.IPPROTO_TCP, tcpUserTimeout, int(s.UserTimeout.Milliseconds()), "TCP_USER_TIMEOUT"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TCP Fast Open constants missing from package syscall.
+const (
+	tcpFastOpen        = 0x17 // TCP_FASTOPEN
+	tcpFastOpenConnect = 0x1e // TCP_FASTOPEN_CONNECT
+	tcpiOptSynData     = 0x20 // TCPI_OPT_SYN_DATA
+	fastOpenQueueLen   = 256
+)
+
+// FastOpenListenControl enables TCP Fast Open on a listening socket. It is
+// meant for net.ListenConfig.Control.
+func FastOpenListenControl(_, _ string, c syscall.RawConn) error {
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		if err := syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, tcpFastOpen, fastOpenQueueLen); err != nil {
+			sockErr = &sockoptError{name: "TCP_FASTOPEN", err: err}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
+
+// fastOpenUsed reports whether data sent in the SYN was acknowledged,
+// on either end of the connection.
+func fastOpenUsed(conn *net.TCPConn) bool {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return false
+	}
+
+	var info syscall.TCPInfo
+	var errno syscall.Errno
+	_ = raw.Control(func(fd uintptr) {
+		size := uint32(unsafe.Sizeof(info))
+		_, _, errno = syscall.Syscall6(syscall.SYS_GETSOCKOPT, fd, syscall.IPPROTO_TCP, syscall.TCP_INFO,
+			uintptr(unsafe.Pointer(&info)), uintptr(unsafe.Pointer(&size)), 0)
+	})
+	return errno == 0 && info.Options&tcpiOptSynData != 0
+}
@@ -0,0 +1,25 @@
+//go:build !linux
+
+package transport
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+// control applies the options to a socket before it connects. Socket
+// tuning is only supported on Linux.
+func (s SocketOptions) control(string, syscall.RawConn) error {
+	return &sockoptError{name: "socket tuning", err: errors.New("not supported on this platform")}
+}
+
+// FastOpenListenControl enables TCP Fast Open on a listening socket, which
+// is only supported on Linux.
+func FastOpenListenControl(_, _ string, _ syscall.RawConn) error {
+	return &sockoptError{name: "TCP_FASTOPEN", err: errors.New("not supported on this platform")}
+}
+
+func fastOpenUsed(*net.TCPConn) bool {
+	return false
+}
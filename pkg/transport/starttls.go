@@ -0,0 +1,377 @@
+package transport
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// StartTLSProtocols lists the protocols supported by StartTLS.
+var StartTLSProtocols = []string{"smtp", "imap", "pop3", "ftp", "xmpp", "ldap", "postgres"}
+
+// StartTLS runs the plaintext preamble of proto on conn up to the point
+// where the server expects a TLS ClientHello. The connection can then be
+// passed to TLSWrapper.Wrap. host is used where the protocol names the
+// server (e.g. the XMPP stream header).
+func StartTLS(conn net.Conn, proto, host string, timeout time.Duration, verbose bool) error {
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+
+	if verbose {
+		fmt.Fprintf(os.Stderr, "Starting %s STARTTLS negotiation\n", strings.ToUpper(proto))
+	}
+
+	s := &starttlsSession{conn: conn, r: bufio.NewReader(conn), verbose: verbose}
+
+	var err error
+	switch strings.ToLower(proto) {
+	case "smtp":
+		err = s.smtp()
+	case "imap":
+		err = s.imap()
+	case "pop3":
+		err = s.pop3()
+	case "ftp":
+		err = s.ftp()
+	case "xmpp":
+		err = s.xmpp(host)
+	case "ldap":
+		err = s.ldap()
+	case "postgres", "postgresql":
+		err = s.postgres()
+	default:
+		return fmt.Errorf("unsupported STARTTLS protocol: %s (supported: %s)",
+			proto, strings.Join(StartTLSProtocols, ", "))
+	}
+	if err != nil {
+		return fmt.Errorf("%s STARTTLS failed: %w", strings.ToUpper(proto), err)
+	}
+
+	// The server must not send anything before our ClientHello; buffered
+	// bytes would otherwise be lost.
+	if s.r.Buffered() > 0 {
+		return fmt.Errorf("%s STARTTLS failed: unexpected data after server response", strings.ToUpper(proto))
+	}
+
+	if verbose {
+		fmt.Fprintln(os.Stderr, "STARTTLS accepted, upgrading to TLS")
+	}
+
+	return conn.SetDeadline(time.Time{})
+}
+
+// starttlsSession is the plaintext side of a STARTTLS negotiation.
+type starttlsSession struct {
+	conn    net.Conn
+	r       *bufio.Reader
+	verbose bool
+}
+
+// send writes a command line terminated by CRLF.
+func (s *starttlsSession) send(line string) error {
+	if s.verbose {
+		fmt.Fprintf(os.Stderr, "C: %s\n", line)
+	}
+	_, err := io.WriteString(s.conn, line+"\r\n")
+	return err
+}
+
+// readLine reads a single response line without the line terminator.
+func (s *starttlsSession) readLine() (string, error) {
+	line, err := s.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if s.verbose {
+		fmt.Fprintf(os.Stderr, "S: %s\n", line)
+	}
+	return line, nil
+}
+
+// readReply reads a (possibly multi-line) SMTP/FTP style reply and checks
+// its code. It returns all lines of the reply.
+func (s *starttlsSession) readReply(code string) ([]string, error) {
+	var lines []string
+	for {
+		line, err := s.readLine()
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, line)
+		if len(line) < 4 || line[3] != '-' {
+			if !strings.HasPrefix(line, code) {
+				return nil, fmt.Errorf("unexpected reply: %s", line)
+			}
+			return lines, nil
+		}
+	}
+}
+
+func (s *starttlsSession) smtp() error {
+	if _, err := s.readReply("220"); err != nil {
+		return err
+	}
+	if err := s.send("EHLO go-connect"); err != nil {
+		return err
+	}
+	caps, err := s.readReply("250")
+	if err != nil {
+		return err
+	}
+	if !hasCapability(caps[1:], "STARTTLS") && s.verbose {
+		fmt.Fprintln(os.Stderr, "Warning: server did not advertise STARTTLS")
+	}
+	if err := s.send("STARTTLS"); err != nil {
+		return err
+	}
+	_, err = s.readReply("220")
+	return err
+}
+
+func (s *starttlsSession) imap() error {
+	line, err := s.readLine()
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "* OK") {
+		return fmt.Errorf("unexpected greeting: %s", line)
+	}
+	if err := s.send("a001 STARTTLS"); err != nil {
+		return err
+	}
+	for {
+		line, err := s.readLine()
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(line, "a001 ") {
+			if !strings.HasPrefix(line, "a001 OK") {
+				return fmt.Errorf("unexpected reply: %s", line)
+			}
+			return nil
+		}
+	}
+}
+
+func (s *starttlsSession) pop3() error {
+	line, err := s.readLine()
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "+OK") {
+		return fmt.Errorf("unexpected greeting: %s", line)
+	}
+	if err := s.send("STLS"); err != nil {
+		return err
+	}
+	if line, err = s.readLine(); err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "+OK") {
+		return fmt.Errorf("unexpected reply: %s", line)
+	}
+	return nil
+}
+
+func (s *starttlsSession) ftp() error {
+	if _, err := s.readReply("220"); err != nil {
+		return err
+	}
+	if err := s.send("AUTH TLS"); err != nil {
+		return err
+	}
+	_, err := s.readReply("234")
+	return err
+}
+
+func (s *starttlsSession) xmpp(host string) error {
+	header := fmt.Sprintf("<?xml version='1.0'?><stream:stream to='%s' xmlns='jabber:client' "+
+		"xmlns:stream='http://etherx.jabber.org/streams' version='1.0'>", host)
+	if err := s.sendRaw(header); err != nil {
+		return err
+	}
+	features, err := s.readUntil("</stream:features>")
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(features, "urn:ietf:params:xml:ns:xmpp-tls") {
+		return errors.New("server did not offer STARTTLS")
+	}
+	if err := s.sendRaw("<starttls xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>"); err != nil {
+		return err
+	}
+	reply, err := s.readUntil("/>")
+	if err != nil {
+		return err
+	}
+	if !strings.Contains(reply, "<proceed") {
+		return fmt.Errorf("unexpected reply: %s", reply)
+	}
+	return nil
+}
+
+// sendRaw writes data without a line terminator.
+func (s *starttlsSession) sendRaw(data string) error {
+	if s.verbose {
+		fmt.Fprintf(os.Stderr, "C: %s\n", data)
+	}
+	_, err := io.WriteString(s.conn, data)
+	return err
+}
+
+// readUntil reads until marker has been received.
+func (s *starttlsSession) readUntil(marker string) (string, error) {
+	var buf bytes.Buffer
+	for !bytes.Contains(buf.Bytes(), []byte(marker)) {
+		b, err := s.r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		buf.WriteByte(b)
+		if buf.Len() > 64*1024 {
+			return "", errors.New("response too large")
+		}
+	}
+	if s.verbose {
+		fmt.Fprintf(os.Stderr, "S: %s\n", buf.String())
+	}
+	return buf.String(), nil
+}
+
+// ldapStartTLSRequest is an LDAPv3 ExtendedRequest (message ID 1) for the
+// StartTLS OID 1.3.6.1.4.1.1466.20037 (RFC 4511 section 4.14).
+var ldapStartTLSRequest = append([]byte{
+	0x30, 0x1d, // LDAPMessage SEQUENCE
+	0x02, 0x01, 0x01, // messageID 1
+	0x77, 0x18, // [APPLICATION 23] ExtendedRequest
+	0x80, 0x16, // [0] requestName
+}, "1.3.6.1.4.1.1466.20037"...)
+
+func (s *starttlsSession) ldap() error {
+	if s.verbose {
+		fmt.Fprintln(os.Stderr, "C: LDAP ExtendedRequest 1.3.6.1.4.1.1466.20037")
+	}
+	if _, err := s.conn.Write(ldapStartTLSRequest); err != nil {
+		return err
+	}
+
+	tag, msg, err := readBER(s.r)
+	if err != nil {
+		return err
+	}
+	if tag != 0x30 {
+		return fmt.Errorf("unexpected LDAP response tag 0x%02x", tag)
+	}
+
+	// Skip the messageID, then expect an ExtendedResponse.
+	msgReader := bufio.NewReader(bytes.NewReader(msg))
+	if _, _, err := readBER(msgReader); err != nil {
+		return err
+	}
+	tag, resp, err := readBER(msgReader)
+	if err != nil {
+		return err
+	}
+	if tag != 0x78 || len(resp) < 3 || resp[0] != 0x0a {
+		return fmt.Errorf("unexpected LDAP response tag 0x%02x", tag)
+	}
+
+	code := resp[2]
+	if s.verbose {
+		fmt.Fprintf(os.Stderr, "S: LDAP ExtendedResponse resultCode=%d\n", code)
+	}
+	if code != 0 {
+		return fmt.Errorf("server returned LDAP result code %d", code)
+	}
+	return nil
+}
+
+// readBER reads a single BER element and returns its tag and contents.
+func readBER(r *bufio.Reader) (byte, []byte, error) {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	first, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	length := int(first)
+	if first&0x80 != 0 {
+		n := int(first & 0x7f)
+		if n == 0 || n > 4 {
+			return 0, nil, fmt.Errorf("unsupported BER length encoding")
+		}
+		length = 0
+		for i := 0; i < n; i++ {
+			b, err := r.ReadByte()
+			if err != nil {
+				return 0, nil, err
+			}
+			length = length<<8 | int(b)
+		}
+	}
+	if length > 64*1024 {
+		return 0, nil, fmt.Errorf("BER element too large")
+	}
+
+	content := make([]byte, length)
+	if _, err := io.ReadFull(r, content); err != nil {
+		return 0, nil, err
+	}
+	return tag, content, nil
+}
+
+// postgresSSLRequestCode is the magic SSLRequest code (1234 << 16 | 5679).
+const postgresSSLRequestCode = 80877103
+
+func (s *starttlsSession) postgres() error {
+	if s.verbose {
+		fmt.Fprintln(os.Stderr, "C: PostgreSQL SSLRequest")
+	}
+	req := make([]byte, 8)
+	binary.BigEndian.PutUint32(req[0:4], 8)
+	binary.BigEndian.PutUint32(req[4:8], postgresSSLRequestCode)
+	if _, err := s.conn.Write(req); err != nil {
+		return err
+	}
+
+	reply, err := s.r.ReadByte()
+	if err != nil {
+		return err
+	}
+	if s.verbose {
+		fmt.Fprintf(os.Stderr, "S: %c\n", reply)
+	}
+	if reply != 'S' {
+		return fmt.Errorf("server refused SSL (reply %q)", reply)
+	}
+	return nil
+}
+
+// hasCapability reports whether the lines of an EHLO reply advertise name.
+func hasCapability(lines []string, name string) bool {
+	for _, line := range lines {
+		if len(line) < 4 {
+			continue
+		}
+		if fields := strings.Fields(line[4:]); len(fields) > 0 && strings.EqualFold(fields[0], name) {
+			return true
+		}
+	}
+	return false
+}
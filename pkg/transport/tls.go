@@ -3,26 +3,146 @@ package transport
 
 import (
 	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"os"
+	"strings"
 	"time"
 )
 
+// TLSOptions holds the client-side TLS settings.
+type TLSOptions struct {
+	// ServerName is the name of the server. It is sent as SNI and used
+	// for certificate verification unless overridden below.
+	ServerName string
+	SkipVerify bool
+	Verbose    bool
+
+	SNI        string // SNI to send instead of ServerName
+	NoSNI      bool   // send no SNI extension at all
+	VerifyName string // name to verify the certificate against (default: SNI, then ServerName)
+
+	// Client certificate for mutual TLS. CertFile may be PEM or PKCS#12;
+	// KeyFile may be omitted if CertFile also contains the key.
+	CertFile    string
+	KeyFile     string
+	KeyPassword string
+
+	// CAFile is a PEM file or directory of trusted CA certificates. By
+	// default it replaces the system roots; with CAAppend it extends them.
+	CAFile   string
+	CAAppend bool
+
+	// ALPN protocols to offer, in order of preference. With RequireALPN
+	// the handshake fails unless the server selects one of them.
+	ALPN        []string
+	RequireALPN bool
+
+	// Protocol constraints; zero values use the crypto/tls defaults.
+	// CipherSuites only applies to TLS 1.0-1.2, as TLS 1.3 suites are not
+	// configurable.
+	MinVersion   uint16
+	MaxVersion   uint16
+	CipherSuites []uint16
+	Curves       []tls.CurveID
+
+	// Pins are SPKI pins (sha256//BASE64). One certificate in the peer
+	// chain must match. With SkipVerify the pins replace CA verification;
+	// otherwise both must succeed.
+	Pins []string
+
+	// SessionCache enables session resumption across Wrap calls.
+	SessionCache tls.ClientSessionCache
+
+	// KeyLogFile receives TLS secrets in NSS key log format, for
+	// decrypting captures in Wireshark.
+	KeyLogFile string
+
+	// ECHConfigList enables Encrypted Client Hello (TLS 1.3 only). With
+	// ECHRetry, DialTLS reconnects once using the retry configs offered by
+	// a server that rejected ECH.
+	ECHConfigList []byte
+	ECHRetry      bool
+
+	// OCSPMode enables revocation checking (OCSPStaple, OCSPRequire or
+	// OCSPFetch). OCSPDial, if set, is used to reach the OCSP responder,
+	// e.g. through the same proxy as the connection.
+	OCSPMode    string
+	OCSPDial    func(network, address string) (net.Conn, error)
+	OCSPTimeout time.Duration
+}
+
 // TLSWrapper wraps a connection with TLS.
 type TLSWrapper struct {
-	serverName string
-	skipVerify bool
-	verbose    bool
+	opts       TLSOptions
+	clientCert *CertificateLoader
+	roots      *x509.CertPool
+	pins       [][]byte
+	keyLog     io.Writer
+	echConfig  []byte
+	ocspResult *OCSPReport
 }
 
 // NewTLSWrapper creates a new TLS wrapper.
-func NewTLSWrapper(serverName string, skipVerify, verbose bool) *TLSWrapper {
-	return &TLSWrapper{
-		serverName: serverName,
-		skipVerify: skipVerify,
-		verbose:    verbose,
+func NewTLSWrapper(opts TLSOptions) (*TLSWrapper, error) {
+	t := &TLSWrapper{opts: opts, echConfig: opts.ECHConfigList}
+
+	if opts.CertFile != "" {
+		loader, err := NewCertificateLoader(opts.CertFile, opts.KeyFile, opts.KeyPassword)
+		if err != nil {
+			return nil, err
+		}
+		t.clientCert = loader
+	} else if opts.KeyFile != "" {
+		return nil, fmt.Errorf("a private key requires a client certificate")
+	}
+
+	if opts.NoSNI && opts.SNI != "" {
+		return nil, fmt.Errorf("cannot both set and disable SNI")
+	}
+
+	if opts.MinVersion != 0 && opts.MaxVersion != 0 && opts.MinVersion > opts.MaxVersion {
+		return nil, fmt.Errorf("minimum TLS version is above the maximum")
+	}
+
+	if _, err := ParseOCSPMode(opts.OCSPMode); err != nil {
+		return nil, err
+	}
+
+	if opts.RequireALPN && len(opts.ALPN) == 0 {
+		return nil, fmt.Errorf("requiring ALPN needs at least one protocol to offer")
+	}
+
+	if opts.CAFile != "" {
+		roots, err := LoadCertPool(opts.CAFile, opts.CAAppend)
+		if err != nil {
+			return nil, err
+		}
+		t.roots = roots
+	}
+
+	pins, err := ParsePins(opts.Pins)
+	if err != nil {
+		return nil, err
+	}
+	t.pins = pins
+
+	if opts.KeyLogFile != "" {
+		keyLog, err := OpenKeyLog(opts.KeyLogFile)
+		if err != nil {
+			return nil, err
+		}
+		t.keyLog = keyLog
+		if opts.Verbose {
+			fmt.Fprintf(os.Stderr, "WARNING: TLS session secrets for %s are being logged to %s.\n", opts.ServerName, opts.KeyLogFile)
+			fmt.Fprintln(os.Stderr, "WARNING: anyone with this file can decrypt the captured traffic.")
+		}
 	}
+
+	return t, nil
 }
 
 // Wrap wraps an existing connection with TLS.
@@ -31,14 +151,12 @@ func (t *TLSWrapper) Wrap(conn net.Conn, timeout time.Duration) (net.Conn, error
 		timeout = 30 * time.Second
 	}
 
-	if t.verbose {
-		fmt.Fprintf(os.Stderr, "Starting TLS handshake with %s\n", t.serverName)
+	if t.opts.Verbose {
+		t.reportStart()
 	}
 
-	config := &tls.Config{
-		ServerName:         t.serverName,
-		InsecureSkipVerify: t.skipVerify,
-	}
+	certRequested := false
+	config := t.config(&certRequested)
 
 	tlsConn := tls.Client(conn, config)
 
@@ -48,6 +166,11 @@ func (t *TLSWrapper) Wrap(conn net.Conn, timeout time.Duration) (net.Conn, error
 
 	if err := tlsConn.Handshake(); err != nil {
 		_ = conn.Close()
+		var echErr *tls.ECHRejectionError
+		if t.opts.Verbose && errors.As(err, &echErr) {
+			fmt.Fprintf(os.Stderr, "ECH rejected by server (retry configs offered: %s)\n",
+				yesNo(len(echErr.RetryConfigList) > 0))
+		}
 		return nil, fmt.Errorf("TLS handshake failed: %w", err)
 	}
 
@@ -56,43 +179,295 @@ func (t *TLSWrapper) Wrap(conn net.Conn, timeout time.Duration) (net.Conn, error
 		return nil, err
 	}
 
-	if t.verbose {
-		state := tlsConn.ConnectionState()
-		fmt.Fprintf(os.Stderr, "TLS established: version=%x, cipher=%s\n", state.Version, tls.CipherSuiteName(state.CipherSuite))
+	state := tlsConn.ConnectionState()
+	if t.opts.RequireALPN && state.NegotiatedProtocol == "" {
+		_ = tlsConn.Close()
+		return nil, fmt.Errorf("server accepted none of the offered ALPN protocols %v", t.opts.ALPN)
+	}
+
+	if t.opts.Verbose {
+		t.report(state, certRequested)
 	}
 
 	return tlsConn, nil
 }
 
-// DialAndWrap connects to a server using TLS directly.
-func DialAndWrap(address string, timeout time.Duration, serverName string, skipVerify, verbose bool) (net.Conn, error) {
-	if timeout == 0 {
-		timeout = 30 * time.Second
+// config returns the client configuration for a handshake, recording in
+// certRequested whether the server asked for a client certificate.
+func (t *TLSWrapper) config(certRequested *bool) *tls.Config {
+	config := &tls.Config{
+		ServerName:         t.sni(),
+		NextProtos:         t.opts.ALPN,
+		MinVersion:         t.opts.MinVersion,
+		MaxVersion:         t.opts.MaxVersion,
+		CipherSuites:       t.opts.CipherSuites,
+		CurvePreferences:   t.opts.Curves,
+		ClientSessionCache: t.opts.SessionCache,
+		KeyLogWriter:       t.keyLog,
+		// Verification is done in verifyConnection so that failures can
+		// report the presented chain.
+		InsecureSkipVerify: true,
+		GetClientCertificate: func(info *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			*certRequested = true
+			return t.clientCertificate(info)
+		},
+		VerifyConnection: t.verifyConnection,
 	}
 
-	if serverName == "" {
-		host, _, _ := net.SplitHostPort(address)
-		serverName = host
+	if len(t.echConfig) > 0 {
+		config.EncryptedClientHelloConfigList = t.echConfig
+		// If ECH is rejected, crypto/tls verifies the client-facing
+		// server against RootCAs and the ECH public name, even with -k.
+		config.RootCAs = t.roots
 	}
+	return config
+}
 
-	if verbose {
-		fmt.Fprintf(os.Stderr, "Connecting to %s with TLS\n", address)
+// reportStart prints the names used for a handshake about to start.
+func (t *TLSWrapper) reportStart() {
+	fmt.Fprintf(os.Stderr, "Starting TLS handshake with %s\n", t.opts.ServerName)
+	sni := t.sni()
+	if sni == "" {
+		sni = "(none)"
 	}
+	fmt.Fprintf(os.Stderr, "TLS SNI: %s, verify name: %s\n", sni, t.verifyName())
+}
 
-	config := &tls.Config{
-		ServerName:         serverName,
-		InsecureSkipVerify: skipVerify,
+// report prints the outcome of a completed handshake.
+func (t *TLSWrapper) report(state tls.ConnectionState, certRequested bool) {
+	fmt.Fprintf(os.Stderr, "TLS established: version=%x, cipher=%s\n", state.Version, tls.CipherSuiteName(state.CipherSuite))
+	if len(t.opts.ALPN) > 0 {
+		if state.NegotiatedProtocol != "" {
+			fmt.Fprintf(os.Stderr, "ALPN negotiated: %s\n", state.NegotiatedProtocol)
+		} else {
+			fmt.Fprintf(os.Stderr, "ALPN: server selected none of %v\n", t.opts.ALPN)
+		}
+	}
+	if state.DidResume {
+		fmt.Fprintln(os.Stderr, "TLS session resumed")
+	}
+	if !certRequested {
+		fmt.Fprintln(os.Stderr, "Server did not request a client certificate")
+	}
+	if len(t.echConfig) > 0 {
+		fmt.Fprintf(os.Stderr, "ECH accepted: %s\n", yesNo(state.ECHAccepted))
+	}
+}
+
+// DialTLS opens a connection with dial and wraps it with TLS. If ECH is
+// rejected and ECHRetry is set, it reconnects once with the server's
+// retry configs.
+func (t *TLSWrapper) DialTLS(dial func() (net.Conn, error), timeout time.Duration) (net.Conn, error) {
+	conn, err := dial()
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConn, err := t.Wrap(conn, timeout)
+	var echErr *tls.ECHRejectionError
+	if err == nil || !t.opts.ECHRetry || !errors.As(err, &echErr) || len(echErr.RetryConfigList) == 0 {
+		return tlsConn, err
+	}
+
+	if t.opts.Verbose {
+		fmt.Fprintln(os.Stderr, "Retrying with the server-provided ECH configs")
+	}
+	t.echConfig = echErr.RetryConfigList
+
+	if conn, err = dial(); err != nil {
+		return nil, err
+	}
+	return t.Wrap(conn, timeout)
+}
+
+// OpenKeyLog opens a key log file for appending, creating it readable
+// by the owner only. Existing files accessible to other users are refused.
+func OpenKeyLog(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open key log file: %w", err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("failed to open key log file: %w", err)
+	}
+	if info.Mode().Perm()&0o077 != 0 {
+		_ = f.Close()
+		return nil, fmt.Errorf("key log file %s is accessible by other users (mode %04o); restrict it to 0600",
+			path, info.Mode().Perm())
+	}
+
+	return f, nil
+}
+
+// sni returns the server name to send in the ClientHello.
+func (t *TLSWrapper) sni() string {
+	switch {
+	case t.opts.NoSNI:
+		return ""
+	case t.opts.SNI != "":
+		return t.opts.SNI
+	default:
+		return t.opts.ServerName
+	}
+}
+
+// verifyName returns the name the peer certificate must be valid for.
+func (t *TLSWrapper) verifyName() string {
+	switch {
+	case t.opts.VerifyName != "":
+		return t.opts.VerifyName
+	case t.opts.SNI != "":
+		return t.opts.SNI
+	default:
+		return t.opts.ServerName
+	}
+}
+
+// verifyConnection verifies the peer chain against the configured roots
+// and pins, then checks revocation if enabled.
+func (t *TLSWrapper) verifyConnection(cs tls.ConnectionState) error {
+	if t.opts.SkipVerify && len(t.pins) == 0 && t.opts.OCSPMode == "" {
+		return nil
+	}
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("server presented no certificates")
+	}
+
+	if len(t.pins) > 0 {
+		if err := t.verifyPins(cs.PeerCertificates); err != nil {
+			return err
+		}
+	}
+
+	chain := cs.PeerCertificates
+	if !t.opts.SkipVerify {
+		intermediates := x509.NewCertPool()
+		for _, cert := range cs.PeerCertificates[1:] {
+			intermediates.AddCert(cert)
+		}
+
+		roots := describeRoots(t.opts.CAFile, t.opts.CAAppend)
+		chains, err := cs.PeerCertificates[0].Verify(x509.VerifyOptions{
+			Roots:         t.roots,
+			Intermediates: intermediates,
+			DNSName:       t.verifyName(),
+		})
+		if err != nil {
+			return fmt.Errorf("certificate verification against %s failed: %w\nPresented chain:\n%s",
+				roots, err, strings.TrimRight(describeChain(cs.PeerCertificates), "\n"))
+		}
+
+		if t.opts.Verbose {
+			fmt.Fprintf(os.Stderr, "Certificate verified against %s, chain:\n%s", roots, describeChain(chains[0]))
+		}
+		chain = chains[0]
+	}
+
+	if t.opts.OCSPMode != "" {
+		var issuer *x509.Certificate
+		if len(chain) > 1 {
+			issuer = chain[1]
+		}
+		report, err := t.checkRevocation(cs.OCSPResponse, chain[0], issuer, cs.DidResume)
+		t.ocspResult = report
+		if t.opts.Verbose && report != nil {
+			fmt.Fprintf(os.Stderr, "OCSP status: %s (%s, produced %s)\n",
+				report.Status, report.Source, formatTime(report.ProducedAt))
+		}
+		if err != nil {
+			return fmt.Errorf("revocation check failed: %w", err)
+		}
+		if t.opts.Verbose && report == nil {
+			fmt.Fprintln(os.Stderr, "OCSP status: not checked (no staple)")
+		}
+	}
+
+	return nil
+}
+
+// Verify checks a connection state as the handshake would, against the
+// configured roots, pins and revocation mode. It lets a handshake made
+// with verification disabled be checked afterwards.
+func (t *TLSWrapper) Verify(state tls.ConnectionState) error {
+	return t.verifyConnection(state)
+}
+
+// OCSPResult returns the OCSP response used by the most recent revocation
+// check, or nil if none was made.
+func (t *TLSWrapper) OCSPResult() *OCSPReport {
+	return t.ocspResult
+}
+
+// verifyPins checks that a certificate in the peer chain matches a pin.
+func (t *TLSWrapper) verifyPins(chain []*x509.Certificate) error {
+	cert := matchPins(t.pins, chain)
+	if cert == nil {
+		return fmt.Errorf("no certificate in the peer chain matches the configured pins\nPresented chain:\n%s",
+			strings.TrimRight(describePins(chain), "\n"))
 	}
 
-	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", address, config)
+	if t.opts.Verbose {
+		fmt.Fprintf(os.Stderr, "Public key pin matched: %s (%s)\n", FormatPin(SPKIHash(cert)), cert.Subject)
+	}
+
+	return nil
+}
+
+// clientCertificate answers a server's certificate request.
+func (t *TLSWrapper) clientCertificate(info *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	if t.clientCert == nil {
+		if t.opts.Verbose {
+			fmt.Fprintln(os.Stderr, "Server requested a client certificate, but none is configured")
+		}
+		return &tls.Certificate{}, nil
+	}
+
+	cert, err := t.clientCert.Certificate()
 	if err != nil {
-		return nil, fmt.Errorf("TLS connection failed: %w", err)
+		return nil, fmt.Errorf("failed to load client certificate: %w", err)
+	}
+
+	if t.opts.Verbose {
+		fmt.Fprintf(os.Stderr, "Server requested a client certificate (%d acceptable CAs)\n", len(info.AcceptableCAs))
+		if err := info.SupportsCertificate(cert); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: client certificate may not be accepted: %v\n", err)
+		} else {
+			fmt.Fprintln(os.Stderr, "Sending client certificate")
+		}
 	}
 
-	if verbose {
-		state := conn.ConnectionState()
-		fmt.Fprintf(os.Stderr, "TLS established: version=%x, cipher=%s\n", state.Version, tls.CipherSuiteName(state.CipherSuite))
+	return cert, nil
+}
+
+// DialAndWrap connects to a server using TLS directly, using dial for the
+// underlying connection on network ("tcp", "unix" or "unixpacket").
+func DialAndWrap(network, address string, timeout time.Duration, dial DialOptions, opts TLSOptions) (net.Conn, error) {
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+
+	if opts.ServerName == "" {
+		host, _, _ := net.SplitHostPort(address)
+		opts.ServerName = host
+	}
+
+	wrapper, err := NewTLSWrapper(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Verbose {
+		fmt.Fprintf(os.Stderr, "Connecting to %s with TLS\n", address)
 	}
 
-	return conn, nil
+	return wrapper.DialTLS(func() (net.Conn, error) {
+		conn, err := dial.Dial(network, address, timeout)
+		if err != nil {
+			return nil, fmt.Errorf("TLS connection failed: %w", err)
+		}
+		return conn, nil
+	}, timeout)
 }
@@ -0,0 +1,88 @@
+package transport
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// tlsVersions lists the protocol versions supported by crypto/tls, oldest first.
+var tlsVersions = []uint16{
+	tls.VersionTLS10,
+	tls.VersionTLS11,
+	tls.VersionTLS12,
+	tls.VersionTLS13,
+}
+
+// ParseTLSVersion parses a protocol version such as "1.2" or "TLS1.3".
+func ParseTLSVersion(s string) (uint16, error) {
+	v := strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(s)), "TLS")
+	switch strings.TrimPrefix(v, "V") {
+	case "1.0", "1":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unknown TLS version: %s", s)
+	}
+}
+
+// ParseCipherSuites maps cipher suite names (as reported by
+// tls.CipherSuiteName) or hex IDs (0xc02f) to suite IDs. Insecure
+// suites are accepted since they are needed for compliance probing.
+func ParseCipherSuites(names []string) ([]uint16, error) {
+	byName := make(map[string]uint16)
+	for _, suite := range allCipherSuites() {
+		byName[suite.Name] = suite.ID
+	}
+
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		if id, ok := byName[strings.ToUpper(name)]; ok {
+			ids = append(ids, id)
+			continue
+		}
+		if strings.HasPrefix(name, "0x") {
+			if id, err := strconv.ParseUint(name[2:], 16, 16); err == nil {
+				ids = append(ids, uint16(id))
+				continue
+			}
+		}
+		return nil, fmt.Errorf("unknown cipher suite: %s", name)
+	}
+	return ids, nil
+}
+
+// ParseCurves maps curve names (X25519, P256, P384, P521, X25519MLKEM768)
+// to curve IDs.
+func ParseCurves(names []string) ([]tls.CurveID, error) {
+	curves := make([]tls.CurveID, 0, len(names))
+	for _, name := range names {
+		switch strings.ToUpper(strings.ReplaceAll(name, "-", "")) {
+		case "X25519":
+			curves = append(curves, tls.X25519)
+		case "P256", "PRIME256V1", "SECP256R1":
+			curves = append(curves, tls.CurveP256)
+		case "P384", "SECP384R1":
+			curves = append(curves, tls.CurveP384)
+		case "P521", "SECP521R1":
+			curves = append(curves, tls.CurveP521)
+		case "X25519MLKEM768":
+			curves = append(curves, tls.X25519MLKEM768)
+		default:
+			return nil, fmt.Errorf("unknown curve: %s", name)
+		}
+	}
+	return curves, nil
+}
+
+// allCipherSuites returns every cipher suite implemented by crypto/tls,
+// including the ones considered insecure.
+func allCipherSuites() []*tls.CipherSuite {
+	return append(tls.CipherSuites(), tls.InsecureCipherSuites()...)
+}